@@ -0,0 +1,301 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/judgegodwins/chess-server/chess"
+	"github.com/judgegodwins/chess-server/fen"
+	"github.com/judgegodwins/chess-server/tokens"
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/judgegodwins/chess-server/ws"
+)
+
+type roomURI struct {
+	RoomID string `uri:"id" binding:"required"`
+}
+
+type moveRequest struct {
+	// optional, checked against the server's position after the move if sent
+	Fen  string          `json:"fen"`
+	Move json.RawMessage `json:"move" binding:"required"`
+}
+
+type drawRequest struct {
+	Action string `json:"action" binding:"required,oneof=offer accept decline"`
+}
+
+// Plays a move for the authenticated user, for bots and clients without a websocket.
+// The move goes through the same validation and is broadcast like a piece_move event.
+func (s *Server) MakeMove(c *gin.Context) {
+	var uri roomURI
+	var data moveRequest
+
+	authPayload, ok := bindGameRequest(c, &uri, &data)
+
+	if !ok {
+		return
+	}
+
+	evt, err := ws.NewEvent(ws.EventPieceMove, ws.PayloadPieceMove{
+		RoomID: uri.RoomID,
+		Fen:    data.Fen,
+		Move:   data.Move,
+	})
+
+	if err != nil {
+		log.Println("error creating piece_move event:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	evt.TraceID = c.GetHeader("X-Trace-ID")
+
+	room, err := s.wsManager.SubmitMove(c.Request.Context(), authPayload.ID, evt)
+
+	respondGameAction(c, "Move played", room, err)
+}
+
+// Resigns the game for the authenticated user
+func (s *Server) Resign(c *gin.Context) {
+	var uri roomURI
+
+	authPayload, ok := bindGameRequest(c, &uri, nil)
+
+	if !ok {
+		return
+	}
+
+	room, err := s.wsManager.Resign(c.Request.Context(), authPayload.ID, uri.RoomID)
+
+	respondGameAction(c, "Game resigned", room, err)
+}
+
+// Offers, accepts or declines a draw for the authenticated user
+func (s *Server) Draw(c *gin.Context) {
+	var uri roomURI
+	var data drawRequest
+
+	authPayload, ok := bindGameRequest(c, &uri, &data)
+
+	if !ok {
+		return
+	}
+
+	room, err := s.wsManager.Draw(c.Request.Context(), authPayload.ID, uri.RoomID, data.Action)
+
+	respondGameAction(c, "Draw "+data.Action+" sent", room, err)
+}
+
+// Exports the game in a room as PGN, including its variant and starting position.
+// Private rooms need their invite code unless the user plays in them.
+func (s *Server) RoomPGN(c *gin.Context) {
+	authPayload, ok := GetPayload(c)
+
+	if !ok {
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		log.Println(errors.New("value in auth_payload key of request context could not be casted to *token.Payload"))
+		return
+	}
+
+	var uri roomURI
+
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return
+	}
+
+	var query inviteCodeQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return
+	}
+
+	room, err := s.rdb.HGetAll(c.Request.Context(), util.GetRoomKey(uri.RoomID)).Result()
+
+	if err != nil {
+		log.Println("error getting room data from redis:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	if len(room) == 0 || !ws.CanSeeRoom(room, authPayload.ID, query.InviteCode) {
+		c.JSON(http.StatusNotFound, errorResponse("room not found"))
+		return
+	}
+
+	game, err := ws.GameFromRoom(room)
+
+	if err != nil {
+		log.Println("error replaying game:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	c.JSON(http.StatusOK, successResponse("Game PGN", gin.H{
+		"pgn": game.PGN(pgnTags(room, game.Variant)),
+	}))
+}
+
+// Returns the PGN tags describing the players and result of the game in a room
+func pgnTags(room map[string]string, variant chess.Variant) map[string]string {
+	white, black := room[util.RoomPlayer1UsernameKey], room[util.RoomPlayer2UsernameKey]
+
+	if room[util.RoomPlayer1ColorKey] == util.ColorBlack {
+		white, black = black, white
+	}
+
+	tags := map[string]string{
+		"Event": "Casual game",
+		"Site":  room[util.RoomIDKey],
+		"White": white,
+		"Black": black,
+	}
+
+	if result := room[util.RoomResultKey]; result != "" {
+		tags["Result"] = result
+		tags["Termination"] = room[util.RoomTerminationKey]
+	}
+
+	// ECO codes classify standard openings only
+	if code := room[util.RoomECOKey]; code != "" && variant == chess.Standard {
+		tags["ECO"] = code
+		tags["Opening"] = room[util.RoomOpeningKey]
+	}
+
+	return tags
+}
+
+type gameURI struct {
+	GameID string `uri:"id" binding:"required"`
+}
+
+// Starts an engine analysis of a finished game the authenticated user played. Progress is
+// pushed over the user's websockets as analysis_progress events.
+func (s *Server) RequestAnalysis(c *gin.Context) {
+	authPayload, ok := GetPayload(c)
+
+	if !ok {
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		log.Println(errors.New("value in auth_payload key of request context could not be casted to *token.Payload"))
+		return
+	}
+
+	var uri gameURI
+
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return
+	}
+
+	analysis, err := s.wsManager.RequestAnalysis(c.Request.Context(), authPayload.ID, uri.GameID)
+
+	switch {
+	case err == nil:
+		c.JSON(http.StatusAccepted, successResponse("Analysis "+analysis.Status, analysis))
+	case errors.Is(err, ws.ErrGameNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(err.Error()))
+	case errors.Is(err, ws.ErrNotAPlayer):
+		c.JSON(http.StatusForbidden, errorResponse(err.Error()))
+	default:
+		log.Println("error requesting analysis:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+	}
+}
+
+// Returns the analysis of a finished game the user played, complete or as far as it got
+func (s *Server) GetAnalysis(c *gin.Context) {
+	authPayload, ok := GetPayload(c)
+
+	if !ok {
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		log.Println(errors.New("value in auth_payload key of request context could not be casted to *token.Payload"))
+		return
+	}
+
+	var uri gameURI
+
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return
+	}
+
+	analysis, err := s.wsManager.GetAnalysis(c.Request.Context(), authPayload.ID, uri.GameID)
+
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, successResponse("Analysis "+analysis.Status, analysis))
+	case errors.Is(err, ws.ErrAnalysisNotFound), errors.Is(err, ws.ErrGameNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(err.Error()))
+	case errors.Is(err, ws.ErrNotAPlayer):
+		c.JSON(http.StatusForbidden, errorResponse(err.Error()))
+	default:
+		log.Println("error getting analysis:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+	}
+}
+
+// Binds the room id and optional JSON body of a game action request and returns the
+// authenticated user. Writes an error response and returns false if anything is missing.
+func bindGameRequest(c *gin.Context, uri *roomURI, body any) (*tokens.Payload, bool) {
+	authPayload, ok := GetPayload(c)
+
+	if !ok {
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		log.Println(errors.New("value in auth_payload key of request context could not be casted to *token.Payload"))
+		return nil, false
+	}
+
+	if err := c.ShouldBindUri(uri); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return nil, false
+	}
+
+	if body != nil {
+		if err := c.ShouldBindJSON(body); err != nil {
+			var maxBytesErr *http.MaxBytesError
+
+			if errors.As(err, &maxBytesErr) {
+				c.JSON(http.StatusRequestEntityTooLarge, errorResponse(err.Error()))
+				return nil, false
+			}
+
+			c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+			return nil, false
+		}
+	}
+
+	return authPayload, true
+}
+
+// Writes the room state after a game action, or maps the action's error to a status code
+func respondGameAction(c *gin.Context, msg string, room map[string]string, err error) {
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, successResponse(msg, ws.NewRoomState(room)))
+	case errors.Is(err, ws.ErrRoomNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(err.Error()))
+	case errors.Is(err, ws.ErrNotAPlayer):
+		c.JSON(http.StatusForbidden, errorResponse(err.Error()))
+	case errors.Is(err, ws.ErrGameNotStarted), errors.Is(err, ws.ErrGameOver),
+		errors.Is(err, ws.ErrNotYourTurn), errors.Is(err, ws.ErrNoDrawOffer):
+		c.JSON(http.StatusConflict, errorResponse(err.Error()))
+	case errors.Is(err, ws.ErrInvalidMove), errors.Is(err, ws.ErrInvalidDrawReply):
+		res := errorResponse(err.Error())
+
+		var fenErr *fen.Error
+
+		if errors.As(err, &fenErr) {
+			res["field"] = fenErr.Field
+		}
+
+		c.JSON(http.StatusUnprocessableEntity, res)
+	default:
+		log.Println("error handling game action:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+	}
+}
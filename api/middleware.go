@@ -1,10 +1,15 @@
 package api
 
 import (
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/judgegodwins/chess-server/metrics"
 	"github.com/judgegodwins/chess-server/tokens"
 )
 
@@ -41,3 +46,45 @@ func (s *Server) AuthMiddleware(c *gin.Context) {
 
 	c.Next()
 }
+
+// Returns a middleware limiting each client IP to the token bucket configured for name.
+// Buckets live in redis so the limit holds across server instances.
+func (s *Server) RateLimitMiddleware(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, ok := s.config.HTTPRateLimits.For(name)
+
+		if !ok {
+			c.Next()
+			return
+		}
+
+		res, err := s.limiter.Allow(c.Request.Context(), fmt.Sprintf("http:%v:%v", name, c.ClientIP()), limit)
+
+		if err != nil {
+			// fail open so a redis hiccup doesn't take the api down
+			log.Println("error checking rate limit:", err)
+			c.Next()
+			return
+		}
+
+		if !res.Allowed {
+			metrics.RateLimited.Add("http:"+name, 1)
+
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, errorResponse("rate limit exceeded"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Returns a middleware limiting request bodies to n bytes
+func BodyLimitMiddleware(n int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+
+		c.Next()
+	}
+}
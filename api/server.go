@@ -1,14 +1,20 @@
 package api
 
 import (
+	"context"
+	"expvar"
 	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/judgegodwins/chess-server/metrics"
+	"github.com/judgegodwins/chess-server/ratelimit"
 	"github.com/judgegodwins/chess-server/util"
 	"github.com/judgegodwins/chess-server/ws"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 type Server struct {
@@ -16,6 +22,11 @@ type Server struct {
 	wsManager *ws.Manager
 	router    *gin.Engine
 	rdb       *redis.Client
+	limiter   *ratelimit.RedisLimiter
+	// spectator streams block in XREAD, so they get their own connections and can't starve everything else
+	streamRdb *redis.Client
+	// a slot for every spectator stream that can be served at once
+	streams chan struct{}
 }
 
 func NewServer(config *util.Config, rdb *redis.Client) *Server {
@@ -26,21 +37,51 @@ func NewServer(config *util.Config, rdb *redis.Client) *Server {
 		wsManager: ws.NewManager(config, rdb),
 		router:    router,
 		rdb:       rdb,
+		limiter:   ratelimit.NewRedisLimiter(rdb),
+		streams:   make(chan struct{}, config.SpectatorStreams),
 	}
 
+	streamOptions := *rdb.Options()
+	streamOptions.PoolSize = config.SpectatorStreams
+	server.streamRdb = redis.NewClient(&streamOptions)
+
+	// c.ClientIP() only believes X-Forwarded-For from the configured proxies
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatalf("error setting trusted proxies: %v", err)
+	}
+
+	// start a span for every request, continuing any W3C trace context sent by the client
+	router.Use(otelgin.Middleware(config.TracingServiceName))
+
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"*"},
-		AllowHeaders:     []string{"*"},
-		AllowCredentials: true,
+		AllowOriginFunc: server.allowOrigin,
+		// wildcards don't cover Authorization, and are taken literally on credentialed requests
+		AllowMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+		AllowHeaders: []string{"Authorization", "Content-Type", "Last-Event-ID", "traceparent", "tracestate"},
+		// never reflect arbitrary origins with credentials
+		AllowCredentials: !config.AllowedOrigins.AllowsAny(),
 	}))
 
 	router.Any("/ws", server.wsManager.ServeWS)
+	router.POST("/ws/ticket", server.AuthMiddleware, server.CreateWSTicket)
+	router.GET("/ws/schema", server.ProtocolSchema)
 	router.StaticFS("/frontend", http.Dir("./frontend"))
-	router.POST("/token", server.TokenGenerator)
+	router.POST("/token", server.RateLimitMiddleware("token"), server.TokenGenerator)
 	router.POST("/token/verify", server.AuthMiddleware, server.GetTokenData)
-	router.POST("/rooms", server.AuthMiddleware, server.CreateRoom)
-	router.GET("/rooms/:id", server.AuthMiddleware, server.CheckRoom)
+	router.POST("/rooms", server.RateLimitMiddleware("rooms"), server.AuthMiddleware, server.CreateRoom)
+	router.GET("/rooms", server.RateLimitMiddleware("rooms"), server.AuthMiddleware, server.ListPublicRooms)
+	router.GET("/rooms/:id", server.RateLimitMiddleware("rooms"), server.AuthMiddleware, server.CheckRoom)
+	router.GET("/invites/:code", server.RateLimitMiddleware("rooms"), server.AuthMiddleware, server.CheckInvite)
+	router.GET("/rooms/:id/pgn", server.RateLimitMiddleware("rooms"), server.AuthMiddleware, server.RoomPGN)
+	// moves are held to the same size limit as websocket messages
+	router.POST("/rooms/:id/moves", server.RateLimitMiddleware("moves"), BodyLimitMiddleware(config.WSReadLimit), server.AuthMiddleware, server.MakeMove)
+	router.POST("/rooms/:id/resign", server.RateLimitMiddleware("moves"), server.AuthMiddleware, server.Resign)
+	router.POST("/rooms/:id/draw", server.RateLimitMiddleware("moves"), server.AuthMiddleware, server.Draw)
+	router.GET("/games/my-turn", server.AuthMiddleware, server.MyTurnGames)
+	router.POST("/games/:id/analysis", server.RateLimitMiddleware("rooms"), server.AuthMiddleware, server.RequestAnalysis)
+	router.GET("/games/:id/analysis", server.AuthMiddleware, server.GetAnalysis)
+	// EventSource can't send an authorization header, spectating is public and private rooms need their invite code
+	router.GET("/rooms/:id/stream", server.RateLimitMiddleware("rooms"), server.StreamRoom)
 
 	router.NoRoute(func(c *gin.Context) {
 		c.JSON(http.StatusNotFound, errorResponse("endpoint not found"))
@@ -49,6 +90,41 @@ func NewServer(config *util.Config, rdb *redis.Client) *Server {
 	return server
 }
 
+// Checks a request origin against the configured allowlist. Disallowed origins get a 403.
+func (s *Server) allowOrigin(origin string) bool {
+	if s.config.AllowedOrigins.Allowed(origin) {
+		return true
+	}
+
+	log.Printf("rejected request from origin %q", origin)
+	metrics.RejectedOrigins.Add("http", 1)
+
+	return false
+}
+
 func (s *Server) Start() error {
+	go s.wsManager.RunCorrespondenceScheduler(context.Background())
+	go s.wsManager.RunJoinRequestScheduler(context.Background())
+	go s.wsManager.RunRoomEventRecorder(context.Background())
+	go s.wsManager.RunChallengeKeepAlive(context.Background())
+	go s.wsManager.RunPresenceKeepAlive(context.Background())
+
+	// metrics expose the command line and memory stats, so they are kept off the public listener
+	if s.config.AdminAddress != "" {
+		go s.serveAdmin()
+	}
+
 	return s.router.Run(fmt.Sprintf(":%v", s.config.Port))
 }
+
+// Serves /debug/vars on the admin address
+func (s *Server) serveAdmin() {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	log.Printf("serving metrics on %v", s.config.AdminAddress)
+
+	if err := http.ListenAndServe(s.config.AdminAddress, mux); err != nil {
+		log.Printf("admin listener stopped: %v", err)
+	}
+}
@@ -1,16 +1,25 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/judgegodwins/chess-server/chess"
+	"github.com/judgegodwins/chess-server/engine"
 	"github.com/judgegodwins/chess-server/tokens"
 	"github.com/judgegodwins/chess-server/util"
+	"github.com/judgegodwins/chess-server/ws"
+	"github.com/redis/go-redis/v9"
 )
 
 type usernameRequest struct {
@@ -61,6 +70,68 @@ func (s *Server) GetTokenData(c *gin.Context) {
 	c.JSON(http.StatusOK, successResponse("success", payload))
 }
 
+// Issues a short-lived, single use ticket for authenticating a websocket connection
+// with /ws?ticket=<ticket>, so the JWT itself never ends up in a URL
+func (s *Server) CreateWSTicket(c *gin.Context) {
+	authPayload, ok := GetPayload(c)
+
+	if !ok {
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		log.Println(errors.New("value in auth_payload key of request context could not be casted to *token.Payload"))
+		return
+	}
+
+	ticket, err := tokens.NewTicket()
+
+	if err != nil {
+		log.Println("error generating ticket:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	b, err := json.Marshal(authPayload)
+
+	if err != nil {
+		log.Println("error marshalling ticket payload:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	if err := s.rdb.Set(c.Request.Context(), util.GetWSTicketKey(ticket), b, util.WSTicketTTL).Err(); err != nil {
+		log.Println("error on rdb.Set:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	c.JSON(http.StatusCreated, successResponse("Websocket ticket", gin.H{
+		"ticket":     ticket,
+		"expires_in": int(util.WSTicketTTL.Seconds()),
+	}))
+}
+
+type createRoomRequest struct {
+	Mode string `json:"mode" binding:"omitempty,oneof=live correspondence"`
+	// days each player has per move in correspondence games
+	DaysPerMove int    `json:"days_per_move" binding:"required_if=Mode correspondence,omitempty,min=1,max=30"`
+	Variant     string `json:"variant" binding:"omitempty,oneof=standard chess960 king_of_the_hill three_check from_position"`
+	// starting position, required for from_position games. Other variants generate their own if it's empty.
+	Fen string `json:"fen" binding:"required_if=Variant from_position"`
+	// colour the creator plays, white by default
+	Color string `json:"color" binding:"omitempty,oneof=w b"`
+	// "bot" to play against the computer, which takes the second seat right away.
+	// The computer doesn't play king_of_the_hill or three_check.
+	Opponent string `json:"opponent" binding:"omitempty,oneof=human bot"`
+	// bot strength from 0 to 20, and milliseconds it thinks per move
+	BotSkill    *int `json:"bot_skill" binding:"omitempty,min=0,max=20"`
+	BotMoveTime int  `json:"bot_move_time" binding:"omitempty,min=100,max=10000"`
+	// who can find the room, unlisted by default
+	Visibility string `json:"visibility" binding:"omitempty,oneof=public unlisted private"`
+	// password opponents need to ask to join. bcrypt only uses the first 72 bytes.
+	Password string `json:"password" binding:"omitempty,min=4,max=72"`
+	// seat the first user who asks to join and start the game, without waiting for accept_join_request
+	AutoAccept bool `json:"auto_accept"`
+}
+
 func (s *Server) CreateRoom(c *gin.Context) {
 	authPayload, ok := GetPayload(c)
 
@@ -70,6 +141,80 @@ func (s *Server) CreateRoom(c *gin.Context) {
 		return
 	}
 
+	var body createRoomRequest
+
+	// the body is optional, rooms default to live games
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return
+	}
+
+	if body.Mode == "" {
+		body.Mode = util.ModeLive
+	}
+
+	if body.Color == "" {
+		body.Color = util.ColorWhite
+	}
+
+	bot := body.Opponent == "bot"
+
+	if bot && body.Mode == util.ModeCorrespondence {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse("games against the computer can't be played by correspondence"))
+		return
+	}
+
+	if bot && body.Password != "" {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse("games against the computer can't have a password"))
+		return
+	}
+
+	if bot && body.AutoAccept {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse("games against the computer already have an opponent"))
+		return
+	}
+
+	if body.Visibility == "" {
+		body.Visibility = ws.VisibilityUnlisted
+	}
+
+	// a position without a variant is played with standard rules
+	if body.Variant == "" && body.Fen != "" {
+		body.Variant = string(chess.FromPosition)
+	} else if body.Variant == "" {
+		body.Variant = string(chess.Standard)
+	}
+
+	// engines play by standard rules and would miss the variant's other ways to win
+	if bot && (body.Variant == string(chess.KingOfTheHill) || body.Variant == string(chess.ThreeCheck)) {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse("the computer can't play king of the hill or three-check"))
+		return
+	}
+
+	initialFEN := body.Fen
+
+	if initialFEN == "" {
+		var err error
+
+		if initialFEN, err = chess.StartingFEN(chess.Variant(body.Variant)); err != nil {
+			log.Println("error generating starting position:", err)
+			c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+			return
+		}
+	}
+
+	game, err := chess.NewGame(chess.Variant(body.Variant), initialFEN)
+
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, fenErrorResponse(err))
+		return
+	}
+
+	if outcome := game.Outcome(); outcome != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse("invalid fen: the game is already over by "+outcome.Termination))
+		return
+	}
+
 	roomID := uuid.NewString()
 
 	data := make(map[string]string)
@@ -77,9 +222,52 @@ func (s *Server) CreateRoom(c *gin.Context) {
 	data[util.RoomIDKey] = roomID
 	data[util.RoomPlayer1Key] = authPayload.ID
 	data[util.RoomPlayer2Key] = ""
-	data[util.RoomGameStateKey] = util.DefaultFEN
+	data[util.RoomGameStateKey] = initialFEN
 	data[util.RoomGameStartedKey] = util.GameStartedFalse.String()
 	data[util.RoomPlayer1UsernameKey] = authPayload.Username
+	data[util.RoomPlayer1ColorKey] = body.Color
+	data[util.RoomModeKey] = body.Mode
+	data[util.RoomVariantKey] = body.Variant
+	data[util.RoomInitialFENKey] = initialFEN
+	data[util.RoomVisibilityKey] = body.Visibility
+
+	if body.Password != "" {
+		hash, err := ws.HashRoomPassword(body.Password)
+
+		if err != nil {
+			log.Println("error hashing room password:", err)
+			c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+			return
+		}
+
+		data[util.RoomPasswordHashKey] = hash
+	}
+
+	if body.AutoAccept {
+		data[util.RoomAutoAcceptKey] = strconv.FormatBool(true)
+	}
+
+	if body.Mode == util.ModeCorrespondence {
+		data[util.RoomDaysPerMoveKey] = strconv.Itoa(body.DaysPerMove)
+	}
+
+	// the bot is seated straight away, so the game starts as soon as the room exists
+	if bot {
+		skill := engine.DefaultSkill
+
+		if body.BotSkill != nil {
+			skill = *body.BotSkill
+		}
+
+		data[util.RoomPlayer2Key] = util.BotPlayerID
+		data[util.RoomPlayer2UsernameKey] = fmt.Sprintf("Computer (level %v)", skill)
+		data[util.RoomGameStartedKey] = util.GameStartedTrue.String()
+		data[util.RoomBotSkillKey] = strconv.Itoa(skill)
+
+		if body.BotMoveTime != 0 {
+			data[util.RoomBotMoveTimeKey] = strconv.Itoa(body.BotMoveTime)
+		}
+	}
 
 	roomKey := util.GetRoomKey(roomID)
 	for k, v := range data {
@@ -92,15 +280,79 @@ func (s *Server) CreateRoom(c *gin.Context) {
 		}
 	}
 
-	s.rdb.Expire(c.Request.Context(), roomKey, 12*time.Hour).Err()
+	// correspondence rooms stop expiring once their game starts
+	s.rdb.Expire(c.Request.Context(), roomKey, util.RoomTTL).Err()
+
+	// rooms waiting for an opponent get a short code to share instead of their id
+	if !bot {
+		code, err := s.createInviteCode(c.Request.Context(), roomID)
+
+		if err != nil {
+			log.Println("error creating invite code:", err)
+			c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+			return
+		}
+
+		data[util.RoomInviteCodeKey] = code
+
+		if body.Visibility == ws.VisibilityPublic {
+			err = s.rdb.ZAdd(c.Request.Context(), util.PublicRoomsKey, redis.Z{Score: float64(time.Now().Unix()), Member: roomID}).Err()
+		}
+
+		if err != nil {
+			log.Println("error listing public room:", err)
+			c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+			return
+		}
+	}
+
+	// the bot opens the game when it plays white
+	if bot {
+		go s.wsManager.PlayBotMove(roomID)
+	}
+
+	// the creator already knows the password
+	delete(data, util.RoomPasswordHashKey)
 
 	c.JSON(http.StatusCreated, successResponse("Room created", data))
 }
 
+// Stores an invite code for the room, picking another one if the code is taken
+func (s *Server) createInviteCode(ctx context.Context, roomID string) (string, error) {
+	for i := 0; i < 5; i++ {
+		code, err := ws.NewInviteCode()
+
+		if err != nil {
+			return "", err
+		}
+
+		ok, err := s.rdb.SetNX(ctx, util.GetInviteKey(code), roomID, util.RoomTTL).Result()
+
+		if err != nil {
+			return "", err
+		}
+
+		if ok {
+			if err := s.rdb.HSet(ctx, util.GetRoomKey(roomID), util.RoomInviteCodeKey, code).Err(); err != nil {
+				return "", err
+			}
+
+			return code, nil
+		}
+	}
+
+	return "", errors.New("no free invite code found")
+}
+
 type checkRoomRequest struct {
 	RoomID string `uri:"id" binding:"required"`
 }
 
+type inviteCodeQuery struct {
+	// needed to see private rooms
+	InviteCode string `form:"invite_code"`
+}
+
 func (s *Server) CheckRoom(c *gin.Context) {
 	var data checkRoomRequest
 
@@ -109,7 +361,57 @@ func (s *Server) CheckRoom(c *gin.Context) {
 		return
 	}
 
-	room, err := s.rdb.HGetAll(c.Request.Context(), util.GetRoomKey(data.RoomID)).Result()
+	var query inviteCodeQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return
+	}
+
+	s.respondRoomInfo(c, data.RoomID, query.InviteCode)
+}
+
+type inviteURI struct {
+	Code string `uri:"code" binding:"required"`
+}
+
+// Looks up the room an invite code was created for
+func (s *Server) CheckInvite(c *gin.Context) {
+	var uri inviteURI
+
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return
+	}
+
+	roomID, err := ws.RoomIDByInviteCode(c.Request.Context(), s.rdb, uri.Code)
+
+	if errors.Is(err, ws.ErrRoomNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse("room not found"))
+		return
+	}
+
+	if err != nil {
+		log.Println("error getting invite code from redis:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	s.respondRoomInfo(c, roomID, uri.Code)
+}
+
+// Responds with what users need to know to join the room, hiding private rooms from users
+// who aren't playing in them and don't have the invite code
+func (s *Server) respondRoomInfo(c *gin.Context, roomID, inviteCode string) {
+	authPayload, ok := GetPayload(c)
+
+	if !ok {
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		log.Println(errors.New("value in auth_payload key of request context could not be casted to *token.Payload"))
+		return
+	}
+
+	room, err := s.rdb.HGetAll(c.Request.Context(), util.GetRoomKey(roomID)).Result()
 
 	if err != nil {
 		log.Println("error getting room data from redis:", err)
@@ -117,13 +419,133 @@ func (s *Server) CheckRoom(c *gin.Context) {
 		return
 	}
 
-	if len(room) == 0 {
+	if len(room) == 0 || !ws.CanSeeRoom(room, authPayload.ID, inviteCode) {
 		c.JSON(http.StatusNotFound, errorResponse("room not found"))
 		return
 	}
 
+	visibility := room[util.RoomVisibilityKey]
+
+	if visibility == "" {
+		visibility = ws.VisibilityUnlisted
+	}
+
 	c.JSON(http.StatusOK, successResponse("room data", gin.H{
-		"id":   room["id"],
-		"full": room[util.RoomPlayer1Key] != "" && room[util.RoomPlayer2Key] != "",
+		"id":                room["id"],
+		"full":              room[util.RoomPlayer1Key] != "" && room[util.RoomPlayer2Key] != "",
+		"visibility":        visibility,
+		"password_required": room[util.RoomPasswordHashKey] != "",
+		"auto_accept":       ws.AutoAccepts(room),
 	}))
 }
+
+// how many public rooms GET /rooms returns
+const publicRoomsLimit = 50
+
+type publicRoom struct {
+	ID               string `json:"id"`
+	Player1Username  string `json:"player1_username"`
+	Player1Color     string `json:"player1_color"`
+	Mode             string `json:"mode"`
+	DaysPerMove      int    `json:"days_per_move,omitempty"`
+	Variant          string `json:"variant"`
+	PasswordRequired bool   `json:"password_required"`
+	AutoAccept       bool   `json:"auto_accept"`
+}
+
+// Lists the public rooms waiting for an opponent, newest first
+func (s *Server) ListPublicRooms(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	ids, err := s.rdb.ZRevRange(ctx, util.PublicRoomsKey, 0, publicRoomsLimit-1).Result()
+
+	if err != nil {
+		log.Println("error listing public rooms:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+
+	_, err = s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, util.GetRoomKey(id))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Println("error getting public rooms:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	rooms := []publicRoom{}
+	stale := []any{}
+
+	for i, cmd := range cmds {
+		room := cmd.Val()
+
+		// expired, or found an opponent without the list being updated
+		if len(room) == 0 || room[util.RoomPlayer2Key] != "" {
+			stale = append(stale, ids[i])
+			continue
+		}
+
+		daysPerMove, _ := strconv.Atoi(room[util.RoomDaysPerMoveKey])
+
+		rooms = append(rooms, publicRoom{
+			ID:               room[util.RoomIDKey],
+			Player1Username:  room[util.RoomPlayer1UsernameKey],
+			Player1Color:     room[util.RoomPlayer1ColorKey],
+			Mode:             room[util.RoomModeKey],
+			DaysPerMove:      daysPerMove,
+			Variant:          room[util.RoomVariantKey],
+			PasswordRequired: room[util.RoomPasswordHashKey] != "",
+			AutoAccept:       ws.AutoAccepts(room),
+		})
+	}
+
+	if len(stale) > 0 {
+		if err := s.rdb.ZRem(ctx, util.PublicRoomsKey, stale...).Err(); err != nil {
+			log.Println("error removing stale public rooms:", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, successResponse("Public rooms", rooms))
+}
+
+// Serves the JSON schema of the websocket protocol
+func (s *Server) ProtocolSchema(c *gin.Context) {
+	schema, err := ws.ProtocolSchema()
+
+	if err != nil {
+		log.Println("error generating protocol schema:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	c.Data(http.StatusOK, "application/schema+json", schema)
+}
+
+// Lists the authenticated user's correspondence games waiting for their move
+func (s *Server) MyTurnGames(c *gin.Context) {
+	authPayload, ok := GetPayload(c)
+
+	if !ok {
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		log.Println(errors.New("value in auth_payload key of request context could not be casted to *token.Payload"))
+		return
+	}
+
+	games, err := s.wsManager.MyTurnGames(c.Request.Context(), authPayload.ID)
+
+	if err != nil {
+		log.Println("error listing correspondence games:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	c.JSON(http.StatusOK, successResponse("Games waiting for your move", games))
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/judgegodwins/chess-server/fen"
+	"github.com/judgegodwins/chess-server/tokens"
+	"github.com/judgegodwins/chess-server/util"
+)
+
+func TestCreateRoomInvalidPosition(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// rejected positions never reach redis
+	s := &Server{config: &util.Config{}}
+
+	tests := []struct {
+		name string
+		fen  string
+		// field named in the response, empty for positions that parse but can't arise in a game
+		field   string
+		message string
+	}{
+		{"syntax error", "8/8/8/8/8/8/8/8 w - -", fen.FieldFEN, "must have 6 fields"},
+		{"bad placement", "4k3/8/8/8/8/8/8/4K4 w - - 0 1", fen.FieldPlacement, "rank 1 has 9 squares"},
+		{"no king", "4k3/8/8/8/8/8/8/8 w - - 0 1", "", "invalid fen: white must have exactly one king"},
+		{"pawn on the back rank", "P3k3/8/8/8/8/8/8/4K3 w - - 0 1", "", "invalid fen: white has a pawn on a8"},
+		{"too many promotions", "4k3/8/8/8/8/8/PPPPPPP1/QQQ1K3 w - - 0 1", "", "invalid fen: white has 7 pawns and 2 promoted pieces"},
+		{"side not to move in check", "4k3/8/8/8/8/8/8/4RK2 w - - 0 1", "", "invalid fen: black is in check but it is white's turn"},
+		{"bad en passant", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR b KQkq e3 0 1", "", "invalid fen: en passant square e3 doesn't follow a double pawn push"},
+		{"game over", "rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3", "", "invalid fen: the game is already over by checkmate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(map[string]string{"fen": tt.fen})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/rooms", strings.NewReader(string(body)))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Set(string(authContextKey), &tokens.Payload{ID: "user", Username: "user"})
+
+			s.CreateRoom(c)
+
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+			}
+
+			var res map[string]string
+
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+
+			if res["status"] != "error" || res["field"] != tt.field || !strings.Contains(res["message"], tt.message) {
+				t.Errorf("response = %v, want field %q and a message containing %q", res, tt.field, tt.message)
+			}
+		})
+	}
+}
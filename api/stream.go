@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/judgegodwins/chess-server/ws"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// how long a read of the room's event stream blocks before a keep-alive is sent
+	streamBlockTimeout = 15 * time.Second
+	// how long a stream is served before it's closed, EventSource reconnects with Last-Event-ID
+	streamMaxDuration = time.Hour
+)
+
+type streamRoomQuery struct {
+	// alternative to the Last-Event-ID header for clients that can't set it
+	LastEventID string `form:"last_event_id"`
+	// needed to watch private rooms
+	InviteCode string `form:"invite_code"`
+}
+
+// Streams a room's events to read-only spectators over server-sent events, for clients
+// that can't use websockets. Each message carries an event encoded as it is sent to
+// websocket clients in the room, and an id that can be sent back as Last-Event-ID to
+// resume after reconnecting. New viewers first receive a room_state event, whose id is
+// the stream position it was taken at. Moves are recorded shortly after they're played,
+// so the first piece_move events after it can already be in its move list and should be
+// dropped by their ply. Private rooms can only be watched with their invite code.
+func (s *Server) StreamRoom(c *gin.Context) {
+	var uri checkRoomRequest
+
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return
+	}
+
+	var query streamRoomQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	streamKey := util.GetRoomEventsKey(uri.RoomID)
+
+	lastID := c.GetHeader("Last-Event-ID")
+
+	if lastID == "" {
+		lastID = query.LastEventID
+	}
+
+	snapshot := lastID == ""
+
+	if snapshot {
+		// taken before the room is read so no event is missed between the snapshot and the stream
+		var err error
+		lastID, err = latestStreamID(ctx, s.rdb, streamKey)
+
+		if err != nil {
+			log.Println("error reading room events:", err)
+			c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+			return
+		}
+	}
+
+	room, err := s.rdb.HGetAll(ctx, util.GetRoomKey(uri.RoomID)).Result()
+
+	if err != nil {
+		log.Println("error getting room data from redis:", err)
+		c.JSON(http.StatusInternalServerError, errorResponse(ErrorMessage500))
+		return
+	}
+
+	// spectators are anonymous, so private rooms need their invite code even for their players
+	if len(room) == 0 || !ws.CanSeeRoom(room, "", query.InviteCode) {
+		c.JSON(http.StatusNotFound, errorResponse("room not found"))
+		return
+	}
+
+	select {
+	case s.streams <- struct{}{}:
+		defer func() { <-s.streams }()
+	default:
+		c.JSON(http.StatusServiceUnavailable, errorResponse("too many spectators, try again later"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, streamMaxDuration)
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// stop nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if snapshot {
+		evt, err := ws.NewEvent(ws.EventRoomState, ws.NewRoomState(room))
+
+		if err != nil {
+			log.Println("error creating room_state event:", err)
+			return
+		}
+
+		if err := writeSSE(c, lastID, evt); err != nil {
+			return
+		}
+	}
+
+	for {
+		streams, err := s.streamRdb.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{streamKey, lastID},
+			Count:   100,
+			Block:   streamBlockTimeout,
+		}).Result()
+
+		if ctx.Err() != nil {
+			// client went away
+			return
+		}
+
+		if errors.Is(err, redis.Nil) {
+			// nothing new, keep the connection from idling out
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+			continue
+		}
+
+		if err != nil {
+			log.Println("error reading room events:", err)
+			return
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				lastID = msg.ID
+
+				data, _ := msg.Values["event"].(string)
+
+				evt, err := ws.JSONCodec{}.Decode([]byte(data))
+
+				if err != nil {
+					log.Println("error decoding room event:", err)
+					continue
+				}
+
+				if err := writeSSE(c, msg.ID, evt); err != nil {
+					return
+				}
+
+				if evt.Type == ws.EventClosingRoom {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Returns the id of the newest entry of a stream, or "0" if it's empty
+func latestStreamID(ctx context.Context, rdb *redis.Client, key string) (string, error) {
+	msgs, err := rdb.XRevRangeN(ctx, key, "+", "-", 1).Result()
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(msgs) == 0 {
+		return "0", nil
+	}
+
+	return msgs[0].ID, nil
+}
+
+// Writes an event as a server-sent event message and flushes it to the client
+func writeSSE(c *gin.Context, id string, evt ws.Event) error {
+	data, err := ws.JSONCodec{}.Encode(evt)
+
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		if _, err := fmt.Fprintf(c.Writer, "id: %v\n", id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(c.Writer, "event: %v\ndata: %s\n\n", evt.Type, data); err != nil {
+		return err
+	}
+
+	c.Writer.Flush()
+
+	return nil
+}
@@ -1,7 +1,10 @@
 package api
 
 import (
+	"errors"
+
 	"github.com/gin-gonic/gin"
+	"github.com/judgegodwins/chess-server/fen"
 	"github.com/judgegodwins/chess-server/tokens"
 )
 
@@ -16,6 +19,21 @@ func errorResponse(msg string) map[string]string {
 	}
 }
 
+// Builds the error response for an invalid FEN, naming the offending field when it is known
+func fenErrorResponse(err error) map[string]string {
+	var fenErr *fen.Error
+
+	// syntax errors already say they are about the fen
+	if errors.As(err, &fenErr) {
+		res := errorResponse(err.Error())
+		res["field"] = fenErr.Field
+
+		return res
+	}
+
+	return errorResponse("invalid fen: " + err.Error())
+}
+
 func successResponse[T interface{}](msg string, data T) map[string]interface{} {
 	return map[string]interface{}{
 		"status":  "success",
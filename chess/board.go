@@ -0,0 +1,166 @@
+// Package chess implements the rules of chess and its variants: move generation,
+// legality, game outcomes and notation.
+package chess
+
+import (
+	"fmt"
+)
+
+type Color int8
+
+const (
+	White Color = iota
+	Black
+)
+
+func (c Color) Other() Color {
+	return c ^ 1
+}
+
+// Returns the colour as written in FEN, "w" or "b"
+func (c Color) String() string {
+	if c == White {
+		return "w"
+	}
+
+	return "b"
+}
+
+type PieceType int8
+
+const (
+	NoPieceType PieceType = iota
+	Pawn
+	Knight
+	Bishop
+	Rook
+	Queen
+	King
+)
+
+// Piece packs a colour and piece type. The zero value is an empty square.
+type Piece int8
+
+const NoPiece Piece = 0
+
+func NewPiece(c Color, t PieceType) Piece {
+	return Piece(t) | Piece(c)<<3
+}
+
+func (p Piece) Type() PieceType {
+	return PieceType(p & 7)
+}
+
+func (p Piece) Color() Color {
+	return Color(p >> 3)
+}
+
+const pieceLetters = " pnbrqk"
+
+// Returns the piece's FEN letter, uppercase for white
+func (p Piece) Letter() byte {
+	letter := pieceLetters[p.Type()]
+
+	if p.Color() == White {
+		letter -= 'a' - 'A'
+	}
+
+	return letter
+}
+
+func pieceFromLetter(letter byte) (Piece, bool) {
+	color := White
+
+	if letter >= 'a' {
+		color = Black
+		letter -= 'a' - 'A'
+	}
+
+	for t := Pawn; t <= King; t++ {
+		if pieceLetters[t]-('a'-'A') == letter {
+			return NewPiece(color, t), true
+		}
+	}
+
+	return NoPiece, false
+}
+
+// Square indexes the board from a1 = 0 to h8 = 63
+type Square int8
+
+const NoSquare Square = -1
+
+func SquareAt(file, rank int) Square {
+	return Square(rank*8 + file)
+}
+
+func (s Square) File() int {
+	return int(s) & 7
+}
+
+func (s Square) Rank() int {
+	return int(s) >> 3
+}
+
+func (s Square) String() string {
+	if s == NoSquare {
+		return "-"
+	}
+
+	return string([]byte{byte('a' + s.File()), byte('1' + s.Rank())})
+}
+
+func ParseSquare(s string) (Square, error) {
+	if len(s) != 2 || s[0] < 'a' || s[0] > 'h' || s[1] < '1' || s[1] > '8' {
+		return NoSquare, fmt.Errorf("invalid square %q", s)
+	}
+
+	return SquareAt(int(s[0]-'a'), int(s[1]-'1')), nil
+}
+
+// Returns the square df files and dr ranks away from s
+func (s Square) offset(df, dr int) (Square, bool) {
+	f, r := s.File()+df, s.Rank()+dr
+
+	if f < 0 || f > 7 || r < 0 || r > 7 {
+		return NoSquare, false
+	}
+
+	return SquareAt(f, r), true
+}
+
+var (
+	knightDeltas = [][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	kingDeltas   = [][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+	// the first four directions are orthogonal, the last four diagonal
+	directions = [8][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}, {1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+)
+
+// precomputed target squares
+var (
+	knightTargets [64][]Square
+	kingTargets   [64][]Square
+	rays          [64][8][]Square
+)
+
+func init() {
+	for s := Square(0); s < 64; s++ {
+		for _, d := range knightDeltas {
+			if t, ok := s.offset(d[0], d[1]); ok {
+				knightTargets[s] = append(knightTargets[s], t)
+			}
+		}
+
+		for _, d := range kingDeltas {
+			if t, ok := s.offset(d[0], d[1]); ok {
+				kingTargets[s] = append(kingTargets[s], t)
+			}
+		}
+
+		for i, d := range directions {
+			for t, ok := s.offset(d[0], d[1]); ok; t, ok = t.offset(d[0], d[1]) {
+				rays[s][i] = append(rays[s][i], t)
+			}
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package chess
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Reasons a game can end by itself, as stored in a room's termination
+const (
+	TerminationCheckmate            = "checkmate"
+	TerminationStalemate            = "stalemate"
+	TerminationInsufficientMaterial = "insufficient_material"
+	TerminationFiftyMoves           = "fifty_moves"
+	TerminationRepetition           = "threefold_repetition"
+	TerminationKingOfTheHill        = "king_of_the_hill"
+	TerminationThreeCheck           = "three_check"
+)
+
+// Results, as written in PGN
+const (
+	ResultWhiteWins = "1-0"
+	ResultBlackWins = "0-1"
+	ResultDraw      = "1/2-1/2"
+)
+
+var ErrGameOver = errors.New("the game is over")
+
+type Outcome struct {
+	Result      string
+	Termination string
+}
+
+// Game is a game of a variant from its starting position, with the moves played so far
+type Game struct {
+	Variant  Variant
+	StartFEN string
+	Position *Position
+	Moves    []Move
+	// moves in UCI and standard algebraic notation
+	UCIs []string
+	SANs []string
+	// checks given by each colour, which decide three-check games
+	Checks [2]int
+
+	start *Position
+	// how often each position occurred, for threefold repetition
+	seen    map[string]int
+	outcome *Outcome
+}
+
+// Starts a game of the variant from startFEN, which has to be a valid position for it
+func NewGame(variant Variant, startFEN string) (*Game, error) {
+	pos, err := NewPosition(startFEN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pos.Validate(); err != nil {
+		return nil, err
+	}
+
+	if variant == Chess960 {
+		pos.Chess960 = true
+	} else if pos.Chess960 {
+		return nil, fmt.Errorf("castling rights %v need the king on the e-file and the rooks in the corners outside chess960", pos.toBoard().Castling)
+	}
+
+	g := &Game{
+		Variant:  variant,
+		StartFEN: startFEN,
+		Position: pos,
+		start:    pos,
+		seen:     map[string]int{},
+	}
+
+	g.seen[pos.Key()]++
+	g.outcome = g.detectOutcome()
+
+	return g, nil
+}
+
+// Plays a sequence of moves in UCI notation, as stored for a game
+func (g *Game) PlayUCI(moves ...string) error {
+	for _, s := range moves {
+		m, err := g.Position.ParseUCI(s)
+
+		if err != nil {
+			return err
+		}
+
+		if err := g.Play(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Plays a legal move and checks whether it ended the game
+func (g *Game) Play(m Move) error {
+	if g.outcome != nil {
+		return ErrGameOver
+	}
+
+	if !g.Position.IsLegal(m) {
+		return fmt.Errorf("illegal move %v", g.Position.UCI(m))
+	}
+
+	mover := g.Position.Turn
+
+	g.UCIs = append(g.UCIs, g.Position.UCI(m))
+	g.SANs = append(g.SANs, g.Position.SAN(m))
+	g.Moves = append(g.Moves, m)
+	g.Position = g.Position.Play(m)
+	g.seen[g.Position.Key()]++
+
+	if g.Position.InCheck() {
+		g.Checks[mover]++
+	}
+
+	g.outcome = g.detectOutcome()
+
+	return nil
+}
+
+// Returns how the game ended, nil while it is in progress
+func (g *Game) Outcome() *Outcome {
+	return g.outcome
+}
+
+func (g *Game) detectOutcome() *Outcome {
+	pos := g.Position
+	// the side that just moved
+	mover := pos.Turn.Other()
+
+	switch g.Variant {
+	case KingOfTheHill:
+		for _, s := range hillSquares {
+			if pos.Board[s] == NewPiece(mover, King) {
+				return &Outcome{Result: winner(mover), Termination: TerminationKingOfTheHill}
+			}
+		}
+	case ThreeCheck:
+		if g.Checks[mover] >= 3 {
+			return &Outcome{Result: winner(mover), Termination: TerminationThreeCheck}
+		}
+	}
+
+	if len(pos.LegalMoves()) == 0 {
+		if pos.InCheck() {
+			return &Outcome{Result: winner(mover), Termination: TerminationCheckmate}
+		}
+
+		return &Outcome{Result: ResultDraw, Termination: TerminationStalemate}
+	}
+
+	if g.insufficientMaterial() {
+		return &Outcome{Result: ResultDraw, Termination: TerminationInsufficientMaterial}
+	}
+
+	if pos.HalfmoveClock >= 100 {
+		return &Outcome{Result: ResultDraw, Termination: TerminationFiftyMoves}
+	}
+
+	if g.seen[pos.Key()] >= 3 {
+		return &Outcome{Result: ResultDraw, Termination: TerminationRepetition}
+	}
+
+	return nil
+}
+
+func (g *Game) insufficientMaterial() bool {
+	switch g.Variant {
+	case KingOfTheHill:
+		// a bare king can still walk to the centre
+		return false
+	case ThreeCheck:
+		// any piece can give check
+		for _, piece := range g.Position.Board {
+			if piece != NoPiece && piece.Type() != King {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return g.Position.InsufficientMaterial()
+}
+
+func winner(c Color) string {
+	if c == White {
+		return ResultWhiteWins
+	}
+
+	return ResultBlackWins
+}
+
+// Identifies a position regardless of its move counters, by its placement, side to move, castling
+// and en passant rights. Positions with the same key count as repetitions.
+func (p *Position) Key() string {
+	fields := strings.Fields(p.FEN())
+
+	return strings.Join(fields[:4], " ")
+}
+
+// Returns the game in PGN. Seven tag roster tags missing from tags are filled with "?" and the
+// result with the game's outcome. Variant, SetUp and FEN tags are added for non-standard games.
+func (g *Game) PGN(tags map[string]string) string {
+	all := map[string]string{}
+
+	for _, tag := range []string{"Event", "Site", "Date", "Round", "White", "Black"} {
+		all[tag] = "?"
+	}
+
+	all["Result"] = "*"
+
+	if g.outcome != nil {
+		all["Result"] = g.outcome.Result
+	}
+
+	// tags win, a game can also end by resignation, agreement or timeout
+	for k, v := range tags {
+		all[k] = v
+	}
+
+	if g.Variant != Standard {
+		all["Variant"] = pgnVariantNames[g.Variant]
+	}
+
+	if g.StartFEN != StandardFEN {
+		all["SetUp"] = "1"
+		all["FEN"] = g.StartFEN
+	}
+
+	var sb strings.Builder
+
+	// the seven tag roster comes first in its fixed order, the rest alphabetically
+	roster := []string{"Event", "Site", "Date", "Round", "White", "Black", "Result"}
+	var rest []string
+
+	for k := range all {
+		if !contains(roster, k) {
+			rest = append(rest, k)
+		}
+	}
+
+	sort.Strings(rest)
+
+	for _, k := range append(roster, rest...) {
+		fmt.Fprintf(&sb, "[%v %q]\n", k, all[k])
+	}
+
+	sb.WriteByte('\n')
+
+	moveNumber := g.start.FullmoveNumber
+	turn := g.start.Turn
+
+	for i, san := range g.SANs {
+		switch {
+		case turn == White:
+			fmt.Fprintf(&sb, "%v. ", moveNumber)
+		case i == 0:
+			fmt.Fprintf(&sb, "%v... ", moveNumber)
+		}
+
+		sb.WriteString(san)
+		sb.WriteByte(' ')
+
+		if turn == Black {
+			moveNumber++
+		}
+
+		turn = turn.Other()
+	}
+
+	sb.WriteString(all["Result"])
+	sb.WriteByte('\n')
+
+	return sb.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
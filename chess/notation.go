@@ -0,0 +1,191 @@
+package chess
+
+import (
+	"fmt"
+	"strings"
+)
+
+var promotionLetters = map[byte]PieceType{'n': Knight, 'b': Bishop, 'r': Rook, 'q': Queen}
+
+// Returns the move in UCI notation. Castling is written as the king's two square move,
+// or as king-takes-rook in chess960 positions.
+func (p *Position) UCI(m Move) string {
+	to := m.To
+
+	if m.Castling && !p.Chess960 {
+		side := queenSide
+
+		if m.To > m.From {
+			side = kingSide
+		}
+
+		to, _ = castlingTargets(p.Turn, side)
+	}
+
+	s := m.From.String() + to.String()
+
+	if m.Promotion != NoPieceType {
+		s += string(pieceLetters[m.Promotion])
+	}
+
+	return s
+}
+
+// Parses a move in UCI notation and checks it is legal. Castling is accepted both as
+// the king's two square move and as king-takes-rook.
+func (p *Position) ParseUCI(s string) (Move, error) {
+	if len(s) != 4 && len(s) != 5 {
+		return NoMove, fmt.Errorf("invalid uci move %q", s)
+	}
+
+	from, err := ParseSquare(s[:2])
+
+	if err != nil {
+		return NoMove, err
+	}
+
+	to, err := ParseSquare(s[2:4])
+
+	if err != nil {
+		return NoMove, err
+	}
+
+	var promotion PieceType
+
+	if len(s) == 5 {
+		var ok bool
+
+		if promotion, ok = promotionLetters[s[4]]; !ok {
+			return NoMove, fmt.Errorf("invalid promotion %q", s[4])
+		}
+	}
+
+	return p.FindMove(from, to, promotion)
+}
+
+// Returns the legal move from one square to another. A king moving onto its own rook,
+// or onto the square castling takes it to, is castling unless that is a plain king move.
+func (p *Position) FindMove(from, to Square, promotion PieceType) (Move, error) {
+	moves := p.LegalMoves()
+
+	for _, m := range moves {
+		if m.From == from && m.To == to && m.Promotion == promotion {
+			return m, nil
+		}
+	}
+
+	for _, m := range moves {
+		if !m.Castling || m.From != from || promotion != NoPieceType {
+			continue
+		}
+
+		side := queenSide
+
+		if m.To > m.From {
+			side = kingSide
+		}
+
+		if kingTo, _ := castlingTargets(p.Turn, side); kingTo == to {
+			return m, nil
+		}
+	}
+
+	return NoMove, fmt.Errorf("illegal move %v%v", from, to)
+}
+
+// Parses a move in standard algebraic notation and checks it is legal. Check, mate
+// and annotation suffixes are optional.
+func (p *Position) ParseSAN(s string) (Move, error) {
+	san := strings.TrimRight(s, "+#!?")
+
+	for _, m := range p.LegalMoves() {
+		if strings.TrimRight(p.SAN(m), "+#") == san {
+			return m, nil
+		}
+	}
+
+	return NoMove, fmt.Errorf("illegal or ambiguous move %q", s)
+}
+
+// Returns the move in standard algebraic notation, including check and mate suffixes
+func (p *Position) SAN(m Move) string {
+	var sb strings.Builder
+
+	piece := p.Board[m.From]
+
+	switch {
+	case m.Castling:
+		if m.To > m.From {
+			sb.WriteString("O-O")
+		} else {
+			sb.WriteString("O-O-O")
+		}
+	case piece.Type() == Pawn:
+		if m.From.File() != m.To.File() {
+			sb.WriteByte(byte('a' + m.From.File()))
+			sb.WriteByte('x')
+		}
+
+		sb.WriteString(m.To.String())
+
+		if m.Promotion != NoPieceType {
+			sb.WriteByte('=')
+			sb.WriteByte(NewPiece(White, m.Promotion).Letter())
+		}
+	default:
+		sb.WriteByte(NewPiece(White, piece.Type()).Letter())
+		sb.WriteString(p.disambiguation(m))
+
+		if p.Board[m.To] != NoPiece {
+			sb.WriteByte('x')
+		}
+
+		sb.WriteString(m.To.String())
+	}
+
+	next := p.Play(m)
+
+	if next.InCheck() {
+		if len(next.LegalMoves()) == 0 {
+			sb.WriteByte('#')
+		} else {
+			sb.WriteByte('+')
+		}
+	}
+
+	return sb.String()
+}
+
+// Returns the file, rank or square needed to tell m apart from moves of
+// other pieces of the same type to the same square
+func (p *Position) disambiguation(m Move) string {
+	piece := p.Board[m.From]
+	sameFile, sameRank, others := false, false, false
+
+	for _, other := range p.LegalMoves() {
+		if other.To != m.To || other.From == m.From || other.Castling || p.Board[other.From] != piece {
+			continue
+		}
+
+		others = true
+
+		if other.From.File() == m.From.File() {
+			sameFile = true
+		}
+
+		if other.From.Rank() == m.From.Rank() {
+			sameRank = true
+		}
+	}
+
+	switch {
+	case !others:
+		return ""
+	case !sameFile:
+		return string(byte('a' + m.From.File()))
+	case !sameRank:
+		return string(byte('1' + m.From.Rank()))
+	default:
+		return m.From.String()
+	}
+}
@@ -0,0 +1,92 @@
+package chess
+
+import "testing"
+
+// Counts the leaf nodes of the legal move tree, depth plies deep
+func perft(p *Position, depth int) int {
+	moves := p.LegalMoves()
+
+	if depth == 1 {
+		return len(moves)
+	}
+
+	nodes := 0
+
+	for _, m := range moves {
+		nodes += perft(p.Play(m), depth-1)
+	}
+
+	return nodes
+}
+
+func TestPerft(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		nodes []int
+	}{
+		{
+			name:  "standard start",
+			fen:   "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+			nodes: []int{20, 400, 8902, 197281},
+		},
+		{
+			// knights in the middle have two moves each, and the sides can't interact in two plies
+			name:  "chess960 start, BBQNNRKR",
+			fen:   "bbqnnrkr/pppppppp/8/8/8/8/PPPPPPPP/BBQNNRKR w HFhf - 0 1",
+			nodes: []int{20, 400},
+		},
+		{
+			// a knight in the corner only has one move
+			name:  "chess960 start, NRBKQBNR",
+			fen:   "nrbkqbnr/pppppppp/8/8/8/8/PPPPPPPP/NRBKQBNR w HBhb - 0 1",
+			nodes: []int{19, 361},
+		},
+		{
+			name:  "kiwipete",
+			fen:   "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+			nodes: []int{48, 2039, 97862},
+		},
+		{
+			name:  "en passant pins",
+			fen:   "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+			nodes: []int{14, 191, 2812, 43238},
+		},
+		{
+			name:  "promotions and castling through check",
+			fen:   "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+			nodes: []int{6, 264, 9467},
+		},
+		{
+			name:  "promotion with a discovered check",
+			fen:   "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+			nodes: []int{44, 1486, 62379},
+		},
+		{
+			name:  "chess960 castling with the king on g1",
+			fen:   "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9",
+			nodes: []int{21, 528, 12189},
+		},
+		{
+			name:  "chess960 castling with the rooks on e and h",
+			fen:   "2nnrbkr/p1qppppp/8/1ppb4/6PP/3PP3/PPP2P2/BQNNRBKR w HEhe - 1 9",
+			nodes: []int{21, 807, 18002},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPosition(tt.fen)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, want := range tt.nodes {
+				if got := perft(p, i+1); got != want {
+					t.Errorf("perft(%v) = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,676 @@
+package chess
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/judgegodwins/chess-server/fen"
+)
+
+const (
+	kingSide  = 0
+	queenSide = 1
+)
+
+type Position struct {
+	Board [64]Piece
+	Turn  Color
+	// squares of the rooks each colour can still castle with, NoSquare if it can't.
+	// Indexed by colour, then king side and queen side.
+	CastlingRooks  [2][2]Square
+	EnPassant      Square
+	HalfmoveClock  int
+	FullmoveNumber int
+	// castling moves are written king-takes-rook in UCI, as chess960 engines expect
+	Chess960 bool
+}
+
+// Parses a FEN string into a position. Castling availability can be written as KQkq,
+// or as rook files (Shredder-FEN and X-FEN) for chess960 positions.
+func NewPosition(s string) (*Position, error) {
+	b, err := fen.Parse(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return FromBoard(b)
+}
+
+func FromBoard(b *fen.Board) (*Position, error) {
+	p := &Position{
+		EnPassant:      NoSquare,
+		HalfmoveClock:  b.HalfmoveClock,
+		FullmoveNumber: b.FullmoveNumber,
+		CastlingRooks:  [2][2]Square{{NoSquare, NoSquare}, {NoSquare, NoSquare}},
+	}
+
+	for i, letter := range b.Placement {
+		if letter == 0 {
+			continue
+		}
+
+		piece, ok := pieceFromLetter(letter)
+
+		if !ok {
+			return nil, fmt.Errorf("invalid piece %q", letter)
+		}
+
+		p.Board[i] = piece
+	}
+
+	if b.Turn == 'b' {
+		p.Turn = Black
+	}
+
+	if b.EnPassant != "-" && b.EnPassant != "" {
+		sq, err := ParseSquare(b.EnPassant)
+
+		if err != nil {
+			return nil, &fen.Error{Field: fen.FieldEnPassant, Value: b.EnPassant, Reason: err.Error()}
+		}
+
+		p.EnPassant = sq
+	}
+
+	if err := p.parseCastling(b.Castling); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *Position) parseCastling(castling string) error {
+	if castling == "-" || castling == "" {
+		return nil
+	}
+
+	for i := 0; i < len(castling); i++ {
+		ch := castling[i]
+		color := White
+
+		if ch >= 'a' {
+			color = Black
+			ch -= 'a' - 'A'
+		}
+
+		king := p.King(color)
+		backRank := 0
+
+		if color == Black {
+			backRank = 7
+		}
+
+		if king == NoSquare || king.Rank() != backRank {
+			return castlingError(castling, "castling right %q without a king on the back rank", castling[i])
+		}
+
+		rook := NoSquare
+
+		switch {
+		case ch == 'K':
+			// the outermost rook on the king's side
+			for f := 7; f > king.File(); f-- {
+				if p.Board[SquareAt(f, backRank)] == NewPiece(color, Rook) {
+					rook = SquareAt(f, backRank)
+					break
+				}
+			}
+		case ch == 'Q':
+			for f := 0; f < king.File(); f++ {
+				if p.Board[SquareAt(f, backRank)] == NewPiece(color, Rook) {
+					rook = SquareAt(f, backRank)
+					break
+				}
+			}
+		case ch >= 'A' && ch <= 'H':
+			if sq := SquareAt(int(ch-'A'), backRank); p.Board[sq] == NewPiece(color, Rook) {
+				rook = sq
+			}
+		default:
+			return castlingError(castling, "invalid castling right %q", castling[i])
+		}
+
+		if rook == NoSquare {
+			return castlingError(castling, "castling right %q without a matching rook", castling[i])
+		}
+
+		side := queenSide
+
+		if rook.File() > king.File() {
+			side = kingSide
+		}
+
+		p.CastlingRooks[color][side] = rook
+
+		// a rook other than the one next to the corner means this is a chess960 position
+		if (side == kingSide && rook.File() != 7) || (side == queenSide && rook.File() != 0) || king.File() != 4 {
+			p.Chess960 = true
+		}
+	}
+
+	return nil
+}
+
+func castlingError(castling, format string, args ...any) error {
+	return &fen.Error{Field: fen.FieldCastling, Value: castling, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Returns the position's FEN. Castling rights are written as KQkq when the castling rook
+// is the outermost one on its side of the king, and as the rook's file otherwise (X-FEN).
+func (p *Position) FEN() string {
+	return p.toBoard().String()
+}
+
+// Returns the piece placement and side to move, which is what clients have to agree on after a move
+func (p *Position) BoardFEN() string {
+	return p.toBoard().PlacementString() + " " + p.Turn.String()
+}
+
+func (p *Position) toBoard() *fen.Board {
+	b := &fen.Board{
+		Turn:           p.Turn.String()[0],
+		EnPassant:      p.EnPassant.String(),
+		HalfmoveClock:  p.HalfmoveClock,
+		FullmoveNumber: p.FullmoveNumber,
+	}
+
+	for i, piece := range p.Board {
+		if piece != NoPiece {
+			b.Placement[i] = piece.Letter()
+		}
+	}
+
+	var castling strings.Builder
+
+	for _, color := range []Color{White, Black} {
+		for _, side := range []int{kingSide, queenSide} {
+			rook := p.CastlingRooks[color][side]
+
+			if rook == NoSquare {
+				continue
+			}
+
+			var ch byte
+
+			if p.isOutermostRook(color, side, rook) {
+				ch = "KQ"[side]
+			} else {
+				ch = byte('A' + rook.File())
+			}
+
+			if color == Black {
+				ch += 'a' - 'A'
+			}
+
+			castling.WriteByte(ch)
+		}
+	}
+
+	b.Castling = castling.String()
+
+	if b.Castling == "" {
+		b.Castling = "-"
+	}
+
+	return b
+}
+
+func (p *Position) isOutermostRook(color Color, side int, rook Square) bool {
+	step := 1
+
+	if side == queenSide {
+		step = -1
+	}
+
+	for f := rook.File() + step; f >= 0 && f <= 7; f += step {
+		if p.Board[SquareAt(f, rook.Rank())] == NewPiece(color, Rook) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the square of the colour's king, NoSquare if it has none
+func (p *Position) King(color Color) Square {
+	king := NewPiece(color, King)
+
+	for s := Square(0); s < 64; s++ {
+		if p.Board[s] == king {
+			return s
+		}
+	}
+
+	return NoSquare
+}
+
+// Reports whether any piece of colour by attacks square s
+func (p *Position) IsAttacked(s Square, by Color) bool {
+	// a pawn of colour by attacks s if it stands diagonally behind s from its point of view
+	pawnRank := -1
+
+	if by == Black {
+		pawnRank = 1
+	}
+
+	for _, df := range []int{-1, 1} {
+		if t, ok := s.offset(df, pawnRank); ok && p.Board[t] == NewPiece(by, Pawn) {
+			return true
+		}
+	}
+
+	for _, t := range knightTargets[s] {
+		if p.Board[t] == NewPiece(by, Knight) {
+			return true
+		}
+	}
+
+	for _, t := range kingTargets[s] {
+		if p.Board[t] == NewPiece(by, King) {
+			return true
+		}
+	}
+
+	for dir := range directions {
+		for _, t := range rays[s][dir] {
+			piece := p.Board[t]
+
+			if piece == NoPiece {
+				continue
+			}
+
+			if piece.Color() == by {
+				switch piece.Type() {
+				case Queen:
+					return true
+				case Rook:
+					if dir < 4 {
+						return true
+					}
+				case Bishop:
+					if dir >= 4 {
+						return true
+					}
+				}
+			}
+
+			break
+		}
+	}
+
+	return false
+}
+
+// Reports whether the side to move is in check
+func (p *Position) InCheck() bool {
+	king := p.King(p.Turn)
+
+	return king != NoSquare && p.IsAttacked(king, p.Turn.Other())
+}
+
+// Move is a move from one square to another. Castling is encoded as the king
+// capturing its own rook, which describes chess960 castling unambiguously.
+type Move struct {
+	From      Square
+	To        Square
+	Promotion PieceType
+	Castling  bool
+}
+
+var NoMove = Move{From: NoSquare, To: NoSquare}
+
+// Returns the pseudo-legal moves of the side to move, which may leave its king in check
+func (p *Position) pseudoLegalMoves() []Move {
+	moves := make([]Move, 0, 48)
+	us := p.Turn
+
+	for s := Square(0); s < 64; s++ {
+		piece := p.Board[s]
+
+		if piece == NoPiece || piece.Color() != us {
+			continue
+		}
+
+		switch piece.Type() {
+		case Pawn:
+			moves = p.appendPawnMoves(moves, s)
+		case Knight:
+			moves = p.appendTargets(moves, s, knightTargets[s])
+		case King:
+			moves = p.appendTargets(moves, s, kingTargets[s])
+		case Bishop:
+			moves = p.appendSliding(moves, s, 4, 8)
+		case Rook:
+			moves = p.appendSliding(moves, s, 0, 4)
+		case Queen:
+			moves = p.appendSliding(moves, s, 0, 8)
+		}
+	}
+
+	return p.appendCastling(moves)
+}
+
+func (p *Position) appendTargets(moves []Move, from Square, targets []Square) []Move {
+	for _, t := range targets {
+		if target := p.Board[t]; target == NoPiece || target.Color() != p.Turn {
+			moves = append(moves, Move{From: from, To: t})
+		}
+	}
+
+	return moves
+}
+
+func (p *Position) appendSliding(moves []Move, from Square, firstDir, lastDir int) []Move {
+	for dir := firstDir; dir < lastDir; dir++ {
+		for _, t := range rays[from][dir] {
+			target := p.Board[t]
+
+			if target == NoPiece {
+				moves = append(moves, Move{From: from, To: t})
+				continue
+			}
+
+			if target.Color() != p.Turn {
+				moves = append(moves, Move{From: from, To: t})
+			}
+
+			break
+		}
+	}
+
+	return moves
+}
+
+func (p *Position) appendPawnMoves(moves []Move, from Square) []Move {
+	forward, startRank, lastRank := 1, 1, 7
+
+	if p.Turn == Black {
+		forward, startRank, lastRank = -1, 6, 0
+	}
+
+	add := func(to Square) {
+		if to.Rank() == lastRank {
+			for _, promotion := range []PieceType{Queen, Rook, Bishop, Knight} {
+				moves = append(moves, Move{From: from, To: to, Promotion: promotion})
+			}
+			return
+		}
+
+		moves = append(moves, Move{From: from, To: to})
+	}
+
+	if to, ok := from.offset(0, forward); ok && p.Board[to] == NoPiece {
+		add(to)
+
+		if to2, ok := to.offset(0, forward); ok && from.Rank() == startRank && p.Board[to2] == NoPiece {
+			add(to2)
+		}
+	}
+
+	for _, df := range []int{-1, 1} {
+		to, ok := from.offset(df, forward)
+
+		if !ok {
+			continue
+		}
+
+		if target := p.Board[to]; (target != NoPiece && target.Color() != p.Turn) || to == p.EnPassant {
+			add(to)
+		}
+	}
+
+	return moves
+}
+
+// Appends the castling moves whose path is clear and not attacked
+func (p *Position) appendCastling(moves []Move) []Move {
+	us := p.Turn
+	king := p.King(us)
+
+	if king == NoSquare {
+		return moves
+	}
+
+	for side, rook := range p.CastlingRooks[us] {
+		if rook == NoSquare || p.Board[rook] != NewPiece(us, Rook) {
+			continue
+		}
+
+		kingTo, rookTo := castlingTargets(us, side)
+
+		// every square the king and rook cross or land on must be empty, besides themselves
+		lo, hi := minSquare(king, kingTo, rook, rookTo), maxSquare(king, kingTo, rook, rookTo)
+		clear := true
+
+		for s := lo; s <= hi; s++ {
+			if s != king && s != rook && p.Board[s] != NoPiece {
+				clear = false
+				break
+			}
+		}
+
+		if !clear {
+			continue
+		}
+
+		// the king can't castle out of, through or into check
+		safe := true
+		step := Square(1)
+
+		if kingTo < king {
+			step = -1
+		}
+
+		for s := king; ; s += step {
+			if p.isAttackedWithout(s, us.Other(), rook) {
+				safe = false
+				break
+			}
+
+			if s == kingTo {
+				break
+			}
+		}
+
+		if safe {
+			moves = append(moves, Move{From: king, To: rook, Castling: true})
+		}
+	}
+
+	return moves
+}
+
+// Reports whether s is attacked, ignoring the castling rook which could otherwise
+// shield the king from a rook or queen on the back rank in chess960
+func (p *Position) isAttackedWithout(s Square, by Color, rook Square) bool {
+	piece := p.Board[rook]
+	p.Board[rook] = NoPiece
+	attacked := p.IsAttacked(s, by)
+	p.Board[rook] = piece
+
+	return attacked
+}
+
+// Returns the squares the king and rook end up on after castling to a side
+func castlingTargets(color Color, side int) (Square, Square) {
+	rank := 0
+
+	if color == Black {
+		rank = 7
+	}
+
+	if side == kingSide {
+		return SquareAt(6, rank), SquareAt(5, rank)
+	}
+
+	return SquareAt(2, rank), SquareAt(3, rank)
+}
+
+func minSquare(squares ...Square) Square {
+	m := squares[0]
+
+	for _, s := range squares[1:] {
+		if s < m {
+			m = s
+		}
+	}
+
+	return m
+}
+
+func maxSquare(squares ...Square) Square {
+	m := squares[0]
+
+	for _, s := range squares[1:] {
+		if s > m {
+			m = s
+		}
+	}
+
+	return m
+}
+
+// Returns the legal moves of the side to move
+func (p *Position) LegalMoves() []Move {
+	pseudo := p.pseudoLegalMoves()
+	legal := pseudo[:0]
+
+	for _, m := range pseudo {
+		next := p.Play(m)
+
+		if king := next.King(p.Turn); king == NoSquare || !next.IsAttacked(king, p.Turn.Other()) {
+			legal = append(legal, m)
+		}
+	}
+
+	return legal
+}
+
+// Reports whether m is one of the legal moves
+func (p *Position) IsLegal(m Move) bool {
+	for _, legal := range p.LegalMoves() {
+		if legal == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the position after playing m, which must be at least pseudo-legal
+func (p *Position) Play(m Move) *Position {
+	next := *p
+	us := p.Turn
+	piece := p.Board[m.From]
+
+	next.EnPassant = NoSquare
+	next.HalfmoveClock++
+
+	if m.Castling {
+		side := queenSide
+
+		if m.To > m.From {
+			side = kingSide
+		}
+
+		kingTo, rookTo := castlingTargets(us, side)
+
+		next.Board[m.From] = NoPiece
+		next.Board[m.To] = NoPiece
+		next.Board[kingTo] = NewPiece(us, King)
+		next.Board[rookTo] = NewPiece(us, Rook)
+		next.CastlingRooks[us] = [2]Square{NoSquare, NoSquare}
+	} else {
+		if p.Board[m.To] != NoPiece {
+			next.HalfmoveClock = 0
+		}
+
+		if piece.Type() == Pawn {
+			next.HalfmoveClock = 0
+
+			// en passant captures the pawn beside the target square
+			if m.To == p.EnPassant && m.To.File() != m.From.File() {
+				next.Board[SquareAt(m.To.File(), m.From.Rank())] = NoPiece
+			}
+
+			// a double push allows en passant if an enemy pawn can take it
+			if d := m.To.Rank() - m.From.Rank(); d == 2 || d == -2 {
+				next.setEnPassant(m.From, m.To)
+			}
+		}
+
+		next.Board[m.To] = piece
+		next.Board[m.From] = NoPiece
+
+		if m.Promotion != NoPieceType {
+			next.Board[m.To] = NewPiece(us, m.Promotion)
+		}
+
+		if piece.Type() == King {
+			next.CastlingRooks[us] = [2]Square{NoSquare, NoSquare}
+		}
+
+		// moving or capturing a castling rook removes its castling right
+		for color := range next.CastlingRooks {
+			for side, rook := range next.CastlingRooks[color] {
+				if rook == m.From || rook == m.To {
+					next.CastlingRooks[color][side] = NoSquare
+				}
+			}
+		}
+	}
+
+	if us == Black {
+		next.FullmoveNumber++
+	}
+
+	next.Turn = us.Other()
+
+	return &next
+}
+
+func (p *Position) setEnPassant(from, to Square) {
+	them := p.Turn.Other()
+
+	for _, df := range []int{-1, 1} {
+		if s, ok := to.offset(df, 0); ok && p.Board[s] == NewPiece(them, Pawn) {
+			p.EnPassant = SquareAt(to.File(), (from.Rank()+to.Rank())/2)
+			return
+		}
+	}
+}
+
+// Reports whether neither side has enough material left to checkmate
+func (p *Position) InsufficientMaterial() bool {
+	var minors [2]int
+	var bishopSquareColors [2]int
+
+	for s := Square(0); s < 64; s++ {
+		piece := p.Board[s]
+
+		switch piece.Type() {
+		case NoPieceType, King:
+			continue
+		case Knight:
+			minors[piece.Color()]++
+		case Bishop:
+			minors[piece.Color()]++
+			bishopSquareColors[(s.File()+s.Rank())%2]++
+		default:
+			return false
+		}
+	}
+
+	total := minors[White] + minors[Black]
+
+	// bare kings, or a single minor piece
+	if total <= 1 {
+		return true
+	}
+
+	// only bishops, all on squares of the same colour
+	knights := total - bishopSquareColors[0] - bishopSquareColors[1]
+
+	return knights == 0 && (bishopSquareColors[0] == 0 || bishopSquareColors[1] == 0)
+}
@@ -0,0 +1,94 @@
+package chess
+
+import (
+	"fmt"
+)
+
+var colorNames = [2]string{"white", "black"}
+
+// Checks that the position could arise in a game: one king per side, piece counts that
+// promotions can explain, no pawns on the back ranks, the side that just moved not left
+// in check and en passant rights matching a double pawn push. Castling rights are
+// checked against the king and rooks when the position is parsed.
+func (p *Position) Validate() error {
+	var counts [2][King + 1]int
+
+	for s := Square(0); s < 64; s++ {
+		piece := p.Board[s]
+
+		if piece == NoPiece {
+			continue
+		}
+
+		counts[piece.Color()][piece.Type()]++
+
+		if piece.Type() == Pawn && (s.Rank() == 0 || s.Rank() == 7) {
+			return fmt.Errorf("%v has a pawn on %v, pawns can't stand on the first or last rank", colorNames[piece.Color()], s)
+		}
+	}
+
+	for color, c := range counts {
+		name := colorNames[color]
+
+		if c[King] != 1 {
+			return fmt.Errorf("%v must have exactly one king, found %v", name, c[King])
+		}
+
+		if c[Pawn] > 8 {
+			return fmt.Errorf("%v has %v pawns, at most 8 are allowed", name, c[Pawn])
+		}
+
+		// pieces beyond the starting set must have been promoted from pawns
+		promoted := max0(c[Queen]-1) + max0(c[Rook]-2) + max0(c[Bishop]-2) + max0(c[Knight]-2)
+
+		if c[Pawn]+promoted > 8 {
+			return fmt.Errorf("%v has %v pawns and %v promoted pieces, more than its 8 pawns could account for", name, c[Pawn], promoted)
+		}
+	}
+
+	them := p.Turn.Other()
+
+	if p.IsAttacked(p.King(them), p.Turn) {
+		return fmt.Errorf("%v is in check but it is %v's turn", colorNames[them], colorNames[p.Turn])
+	}
+
+	if p.EnPassant != NoSquare {
+		if err := p.validateEnPassant(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checks the en passant square lies behind a pawn of the side that just moved, which could have
+// come from two squares further back
+func (p *Position) validateEnPassant() error {
+	forward, rank := 1, 5
+
+	if p.Turn == Black {
+		forward, rank = -1, 2
+	}
+
+	if p.EnPassant.Rank() != rank {
+		return fmt.Errorf("en passant square %v must be on rank %v with %v to move", p.EnPassant, rank+1, colorNames[p.Turn])
+	}
+
+	// the square the pawn passed over and the one it started on must be empty
+	start, _ := p.EnPassant.offset(0, forward)
+	pawn, _ := p.EnPassant.offset(0, -forward)
+
+	if p.Board[p.EnPassant] != NoPiece || p.Board[start] != NoPiece || p.Board[pawn] != NewPiece(p.Turn.Other(), Pawn) {
+		return fmt.Errorf("en passant square %v doesn't follow a double pawn push", p.EnPassant)
+	}
+
+	return nil
+}
+
+func max0(n int) int {
+	if n < 0 {
+		return 0
+	}
+
+	return n
+}
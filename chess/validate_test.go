@@ -0,0 +1,59 @@
+package chess
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		// part of the error, empty if the position is valid
+		err string
+	}{
+		{"standard start", StandardFEN, ""},
+		{"bare kings", "4k3/8/8/8/8/8/8/4K3 w - - 0 1", ""},
+		{"no white king", "4k3/8/8/8/8/8/8/8 w - - 0 1", "white must have exactly one king, found 0"},
+		{"two black kings", "4k2k/8/8/8/8/8/8/4K3 w - - 0 1", "black must have exactly one king, found 2"},
+
+		{"white pawn on the last rank", "P3k3/8/8/8/8/8/8/4K3 w - - 0 1", "white has a pawn on a8"},
+		{"black pawn on the first rank", "4k3/8/8/8/8/8/8/p3K3 w - - 0 1", "black has a pawn on a1"},
+		{"nine pawns", "4k3/8/8/8/8/P7/PPPPPPPP/4K3 w - - 0 1", "white has 9 pawns"},
+
+		{"a queen promoted from a missing pawn", "4k3/8/8/8/8/8/PPPPPPP1/QQ2K3 w - - 0 1", ""},
+		{"two queens promoted from one missing pawn", "4k3/8/8/8/8/8/PPPPPPP1/QQQ1K3 w - - 0 1", "white has 7 pawns and 2 promoted pieces"},
+		{"a third knight with every pawn on the board", "4k3/8/8/8/8/8/PPPPPPPP/NNN1K3 w - - 0 1", "white has 8 pawns and 1 promoted pieces"},
+		{"black promotions", "qqq1k3/1ppppppp/8/8/8/8/8/4K3 w - - 0 1", "black has 7 pawns and 2 promoted pieces"},
+
+		{"side not to move in check", "4k3/8/8/8/8/8/8/4RK2 w - - 0 1", "black is in check but it is white's turn"},
+		{"side to move in check", "4k3/8/8/8/8/8/8/4RK2 b - - 0 1", ""},
+
+		{"en passant after e4", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", ""},
+		{"en passant after e5", "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2", ""},
+		{"en passant on the wrong side", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e6 0 1", "must be on rank 3 with black to move"},
+		{"en passant without a pawn", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR b KQkq e3 0 1", "doesn't follow a double pawn push"},
+		{"en passant with the start square taken", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPPNPPP/RNBQKB1R b KQkq e3 0 1", "doesn't follow a double pawn push"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPosition(tt.fen)
+
+			if err != nil {
+				t.Fatalf("NewPosition(%v): %v", tt.fen, err)
+			}
+
+			err = p.Validate()
+
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Validate() = %v, want no error", err)
+			case tt.err != "" && err == nil:
+				t.Errorf("Validate() = nil, want an error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package chess
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+type Variant string
+
+const (
+	Standard      Variant = "standard"
+	Chess960      Variant = "chess960"
+	KingOfTheHill Variant = "king_of_the_hill"
+	ThreeCheck    Variant = "three_check"
+	// standard rules from a custom starting position
+	FromPosition Variant = "from_position"
+)
+
+var Variants = []Variant{Standard, Chess960, KingOfTheHill, ThreeCheck, FromPosition}
+
+const StandardFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// the variant's name in a PGN Variant tag
+var pgnVariantNames = map[Variant]string{
+	Standard:      "Standard",
+	Chess960:      "Chess960",
+	KingOfTheHill: "King of the Hill",
+	ThreeCheck:    "Three-check",
+	FromPosition:  "From Position",
+}
+
+func ParseVariant(s string) (Variant, error) {
+	for _, v := range Variants {
+		if string(v) == s {
+			return v, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown variant %q", s)
+}
+
+// Returns a starting position for the variant. Chess960 gets a random one of its 960
+// positions, FromPosition has no starting position of its own and returns an error.
+func StartingFEN(v Variant) (string, error) {
+	switch v {
+	case Standard, KingOfTheHill, ThreeCheck:
+		return StandardFEN, nil
+	case Chess960:
+		return Chess960FEN(rand.Intn(960)), nil
+	}
+
+	return "", fmt.Errorf("variant %v needs a starting fen", v)
+}
+
+// Returns the chess960 starting position with the given Scharnagl number, from 0 to 959.
+// Number 518 is the standard starting position.
+func Chess960FEN(n int) string {
+	var rank [8]byte
+
+	// places piece on the i-th of the empty squares
+	placeEmpty := func(piece byte, i int) {
+		for f := range rank {
+			if rank[f] != 0 {
+				continue
+			}
+
+			if i == 0 {
+				rank[f] = piece
+				return
+			}
+
+			i--
+		}
+	}
+
+	// bishops on a light and a dark square
+	rank[n%4*2+1] = 'b'
+	n /= 4
+	rank[n%4*2] = 'b'
+	n /= 4
+
+	placeEmpty('q', n%6)
+	n /= 6
+
+	// the remaining number picks where the two knights go among the five empty squares
+	knights := [10][2]int{{0, 1}, {0, 2}, {0, 3}, {0, 4}, {1, 2}, {1, 3}, {1, 4}, {2, 3}, {2, 4}, {3, 4}}[n]
+	placeEmpty('n', knights[1])
+	placeEmpty('n', knights[0])
+
+	// the king goes between the rooks on the last three squares
+	placeEmpty('r', 0)
+	placeEmpty('k', 0)
+	placeEmpty('r', 0)
+
+	black := string(rank[:])
+
+	return fmt.Sprintf("%v/pppppppp/8/8/8/8/PPPPPPPP/%v w KQkq - 0 1", black, strings.ToUpper(black))
+}
+
+// squares a king has to reach to win king of the hill
+var hillSquares = [4]Square{SquareAt(3, 3), SquareAt(4, 3), SquareAt(3, 4), SquareAt(4, 4)}
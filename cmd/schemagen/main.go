@@ -0,0 +1,32 @@
+// Writes the JSON schema of the websocket protocol, for client teams to validate events against.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/judgegodwins/chess-server/ws"
+)
+
+func main() {
+	out := flag.String("o", "", "output file, defaults to stdout")
+	flag.Parse()
+
+	schema, err := ws.ProtocolSchema()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	schema = append(schema, '\n')
+
+	if *out == "" {
+		os.Stdout.Write(schema)
+		return
+	}
+
+	if err := os.WriteFile(*out, schema, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,81 @@
+// Package eco names chess openings by their Encyclopaedia of Chess Openings code,
+// from a table of common lines embedded in the binary.
+package eco
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"github.com/judgegodwins/chess-server/chess"
+)
+
+// tab separated eco code, name and moves in PGN movetext, one opening per line after a header
+//
+//go:embed openings.tsv
+var openingsTSV string
+
+type Opening struct {
+	ECO  string
+	Name string
+}
+
+// openings by the key of the position their moves reach, so transpositions are recognised
+var openings = mustLoad(openingsTSV)
+
+func mustLoad(tsv string) map[string]Opening {
+	byPosition, err := load(tsv)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return byPosition
+}
+
+func load(tsv string) (map[string]Opening, error) {
+	byPosition := map[string]Opening{}
+	lines := strings.Split(strings.TrimSpace(tsv), "\n")
+
+	for i, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("eco line %d: expected 3 fields, got %d", i+2, len(fields))
+		}
+
+		pos, err := chess.NewPosition(chess.StandardFEN)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, san := range strings.Fields(fields[2]) {
+			// skip move numbers
+			if strings.HasSuffix(san, ".") {
+				continue
+			}
+
+			m, err := pos.ParseSAN(san)
+
+			if err != nil {
+				return nil, fmt.Errorf("eco line %d: %w", i+2, err)
+			}
+
+			pos = pos.Play(m)
+		}
+
+		// the first line naming a position wins
+		if _, ok := byPosition[pos.Key()]; !ok {
+			byPosition[pos.Key()] = Opening{ECO: fields[0], Name: fields[1]}
+		}
+	}
+
+	return byPosition, nil
+}
+
+// Returns the opening the position belongs to, if it is in the table
+func Lookup(pos *chess.Position) (Opening, bool) {
+	opening, ok := openings[pos.Key()]
+	return opening, ok
+}
@@ -0,0 +1,312 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/judgegodwins/chess-server/chess"
+)
+
+const (
+	mateScore = 100000
+	infinity  = 1000000
+)
+
+var errSearchTimeout = errors.New("search timed out")
+
+// Builtin is a small alpha-beta engine with piece-square table evaluation, used when no
+// UCI engine is configured. Lower skill levels search shallower and pick among moves
+// close to the best one at random. Like UCIPool, it runs a fixed number of searches at once.
+type Builtin struct {
+	// depth searched at the highest skill level
+	maxDepth int
+	// a slot for every search that can run at once
+	searches chan struct{}
+}
+
+func NewBuiltin(maxDepth, size int) *Builtin {
+	return &Builtin{
+		maxDepth: maxDepth,
+		searches: make(chan struct{}, size),
+	}
+}
+
+func (b *Builtin) BestMove(ctx context.Context, req Request) (string, error) {
+	pos, moves, scores, err := b.searchRoot(ctx, req)
+
+	if err != nil {
+		return "", err
+	}
+
+	if scores == nil {
+		// not even a one ply search finished, play anything legal
+		return pos.UCI(moves[rand.Intn(len(moves))]), nil
+	}
+
+	// weaker bots settle for moves up to this many centipawns worse than the best
+	margin := (MaxSkill - req.Skill) * 15
+	candidates := 1
+
+	for candidates < len(moves) && scores[candidates] >= scores[0]-margin {
+		candidates++
+	}
+
+	return pos.UCI(moves[rand.Intn(candidates)]), nil
+}
+
+func (b *Builtin) Evaluate(ctx context.Context, req Request) (Evaluation, error) {
+	pos, moves, scores, err := b.searchRoot(ctx, req)
+
+	if err != nil {
+		return Evaluation{}, err
+	}
+
+	if scores == nil {
+		return Evaluation{}, errSearchTimeout
+	}
+
+	eval := Evaluation{BestMove: pos.UCI(moves[0]), Score: scores[0]}
+
+	// mate scores count plies from the root
+	switch {
+	case scores[0] > mateScore-1000:
+		eval.Score, eval.Mate = 0, (mateScore-scores[0]+1)/2
+	case scores[0] < -mateScore+1000:
+		eval.Score, eval.Mate = 0, -(mateScore+scores[0])/2
+	}
+
+	return eval, nil
+}
+
+// Searches the request's position with iterative deepening up to the depth its skill allows,
+// and returns the legal moves sorted by the scores of the deepest search that finished in time.
+// Scores are nil if not even a one ply search finished.
+func (b *Builtin) searchRoot(ctx context.Context, req Request) (*chess.Position, []chess.Move, []int, error) {
+	// searches are CPU bound, waiting for a slot beats slowing every running search down
+	select {
+	case b.searches <- struct{}{}:
+		defer func() { <-b.searches }()
+	case <-ctx.Done():
+		return nil, nil, nil, ctx.Err()
+	}
+
+	pos, err := chess.NewPosition(req.StartFEN)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pos.Chess960 = req.Chess960
+
+	for _, uci := range req.Moves {
+		m, err := pos.ParseUCI(uci)
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		pos = pos.Play(m)
+	}
+
+	moves := pos.LegalMoves()
+
+	if len(moves) == 0 {
+		return nil, nil, nil, errors.New("no legal moves")
+	}
+
+	depth := 1 + req.Skill*(b.maxDepth-1)/MaxSkill
+
+	s := &search{
+		ctx:      ctx,
+		deadline: time.Now().Add(req.MoveTime),
+	}
+
+	var scores []int
+
+	for d := 1; d <= depth; d++ {
+		current, err := s.rootScores(pos, moves, d)
+
+		if err != nil {
+			break
+		}
+
+		// search the best moves first at the next depth
+		sort.Sort(byScore{moves, current})
+		scores = current
+	}
+
+	return pos, moves, scores, nil
+}
+
+// sorts moves by their scores, best first
+type byScore struct {
+	moves  []chess.Move
+	scores []int
+}
+
+func (b byScore) Len() int           { return len(b.moves) }
+func (b byScore) Less(i, j int) bool { return b.scores[i] > b.scores[j] }
+
+func (b byScore) Swap(i, j int) {
+	b.moves[i], b.moves[j] = b.moves[j], b.moves[i]
+	b.scores[i], b.scores[j] = b.scores[j], b.scores[i]
+}
+
+type search struct {
+	ctx      context.Context
+	deadline time.Time
+	nodes    int
+}
+
+// Counts a node and reports whether the search ran out of time
+func (s *search) timedOut() bool {
+	s.nodes++
+
+	// checking the clock on every node is wasteful
+	return s.nodes%1024 == 0 && (time.Now().After(s.deadline) || s.ctx.Err() != nil)
+}
+
+// Returns the score of each root move searched to depth
+func (s *search) rootScores(pos *chess.Position, moves []chess.Move, depth int) ([]int, error) {
+	scores := make([]int, len(moves))
+
+	for i, m := range moves {
+		score, err := s.negamax(pos.Play(m), depth-1, -infinity, infinity, 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		scores[i] = -score
+	}
+
+	return scores, nil
+}
+
+func (s *search) negamax(pos *chess.Position, depth, alpha, beta, ply int) (int, error) {
+	if s.timedOut() {
+		return 0, errSearchTimeout
+	}
+
+	if depth <= 0 {
+		return s.quiescence(pos, alpha, beta)
+	}
+
+	moves := pos.LegalMoves()
+
+	if len(moves) == 0 {
+		if pos.InCheck() {
+			// prefer the quickest mate
+			return -mateScore + ply, nil
+		}
+
+		return 0, nil
+	}
+
+	if pos.HalfmoveClock >= 100 || pos.InsufficientMaterial() {
+		return 0, nil
+	}
+
+	orderMoves(pos, moves)
+
+	for _, m := range moves {
+		score, err := s.negamax(pos.Play(m), depth-1, -beta, -alpha, ply+1)
+
+		if err != nil {
+			return 0, err
+		}
+
+		score = -score
+
+		if score >= beta {
+			return beta, nil
+		}
+
+		if score > alpha {
+			alpha = score
+		}
+	}
+
+	return alpha, nil
+}
+
+// Searches captures only, so positions aren't evaluated in the middle of an exchange
+func (s *search) quiescence(pos *chess.Position, alpha, beta int) (int, error) {
+	if s.timedOut() {
+		return 0, errSearchTimeout
+	}
+
+	standPat := evaluate(pos)
+
+	if standPat >= beta {
+		return beta, nil
+	}
+
+	if standPat > alpha {
+		alpha = standPat
+	}
+
+	moves := pos.LegalMoves()
+	captures := moves[:0]
+
+	for _, m := range moves {
+		if isCapture(pos, m) || m.Promotion == chess.Queen {
+			captures = append(captures, m)
+		}
+	}
+
+	orderMoves(pos, captures)
+
+	for _, m := range captures {
+		score, err := s.quiescence(pos.Play(m), -beta, -alpha)
+
+		if err != nil {
+			return 0, err
+		}
+
+		score = -score
+
+		if score >= beta {
+			return beta, nil
+		}
+
+		if score > alpha {
+			alpha = score
+		}
+	}
+
+	return alpha, nil
+}
+
+func isCapture(pos *chess.Position, m chess.Move) bool {
+	if m.Castling {
+		return false
+	}
+
+	if pos.Board[m.To] != chess.NoPiece {
+		return true
+	}
+
+	// en passant
+	return m.To == pos.EnPassant && pos.Board[m.From].Type() == chess.Pawn
+}
+
+// Sorts captures of valuable pieces by cheap ones first (MVV-LVA), then promotions
+func orderMoves(pos *chess.Position, moves []chess.Move) {
+	priority := func(m chess.Move) int {
+		p := pieceValues[m.Promotion]
+
+		if isCapture(pos, m) {
+			p += 10*pieceValues[pos.Board[m.To].Type()] - pieceValues[pos.Board[m.From].Type()]
+		}
+
+		return p
+	}
+
+	sort.SliceStable(moves, func(i, j int) bool {
+		return priority(moves[i]) > priority(moves[j])
+	})
+}
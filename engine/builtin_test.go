@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/judgegodwins/chess-server/chess"
+)
+
+func TestBuiltinPlaysLegalMoves(t *testing.T) {
+	b := NewBuiltin(3, 1)
+
+	tests := []Request{
+		{StartFEN: chess.StandardFEN},
+		{StartFEN: chess.StandardFEN, Moves: []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1b5"}},
+		// white can only castle king-takes-rook in chess960
+		{StartFEN: "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", Chess960: true},
+	}
+
+	for _, req := range tests {
+		for _, skill := range []int{MinSkill, DefaultSkill, MaxSkill} {
+			req.Skill = skill
+			req.MoveTime = time.Second
+
+			move, err := b.BestMove(context.Background(), req)
+
+			if err != nil {
+				t.Fatalf("BestMove(%v, %v): %v", req.StartFEN, req.Moves, err)
+			}
+
+			pos, err := chess.NewPosition(req.StartFEN)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pos.Chess960 = req.Chess960
+
+			for _, uci := range req.Moves {
+				m, err := pos.ParseUCI(uci)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				pos = pos.Play(m)
+			}
+
+			if m, err := pos.ParseUCI(move); err != nil || !pos.IsLegal(m) {
+				t.Errorf("BestMove(%v, %v) at skill %v = %v, not a legal move", req.StartFEN, req.Moves, skill, move)
+			}
+		}
+	}
+}
+
+func TestBuiltinFindsMateInOne(t *testing.T) {
+	b := NewBuiltin(2, 1)
+
+	tests := []struct {
+		fen  string
+		mate string
+	}{
+		// back rank mate
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "a1a8"},
+		// scholar's mate
+		{"r1bqkbnr/pppp1ppp/2n5/4p3/2B1P3/5Q2/PPPP1PPP/RNB1K1NR w KQkq - 4 4", "f3f7"},
+		// black to move
+		{"3r2k1/8/8/8/8/8/5PPP/6K1 b - - 0 1", "d8d1"},
+	}
+
+	for _, tt := range tests {
+		req := Request{StartFEN: tt.fen, Skill: MaxSkill, MoveTime: 5 * time.Second}
+
+		move, err := b.BestMove(context.Background(), req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if move != tt.mate {
+			t.Errorf("BestMove(%v) = %v, want %v", tt.fen, move, tt.mate)
+		}
+
+		eval, err := b.Evaluate(context.Background(), req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if eval.BestMove != tt.mate || eval.Mate != 1 {
+			t.Errorf("Evaluate(%v) = %+v, want mate in 1 with %v", tt.fen, eval, tt.mate)
+		}
+	}
+}
+
+func TestBuiltinWaitsForAFreeSearch(t *testing.T) {
+	b := NewBuiltin(2, 1)
+
+	// take the only search slot
+	b.searches <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := b.BestMove(ctx, Request{StartFEN: chess.StandardFEN, Skill: MaxSkill, MoveTime: time.Second})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("BestMove with every search slot taken = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
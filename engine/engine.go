@@ -0,0 +1,43 @@
+// Package engine finds moves for bots, using external UCI engines such as Stockfish
+// or a small built-in engine.
+package engine
+
+import (
+	"context"
+	"time"
+)
+
+// Skill levels, as in Stockfish's "Skill Level" option
+const (
+	MinSkill     = 0
+	MaxSkill     = 20
+	DefaultSkill = 10
+)
+
+// Request describes the position an engine should find a move in
+type Request struct {
+	// starting position and the moves played from it in UCI notation
+	StartFEN string
+	Moves    []string
+	// castling moves are written king-takes-rook
+	Chess960 bool
+	Skill    int
+	MoveTime time.Duration
+}
+
+// Evaluation is an engine's verdict on a position
+type Evaluation struct {
+	// the move the engine would play in UCI notation
+	BestMove string
+	// centipawns from the point of view of the side to move
+	Score int
+	// moves until mate when the engine sees one, negative if the side to move gets mated. 0 otherwise.
+	Mate int
+}
+
+// Engine picks moves for bots and evaluates positions for game analysis
+type Engine interface {
+	// Returns the move to play in UCI notation
+	BestMove(ctx context.Context, req Request) (string, error)
+	Evaluate(ctx context.Context, req Request) (Evaluation, error)
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"context"
+	"log"
+)
+
+// UCIPool runs up to a fixed number of engine processes, each searching one position at a time.
+// Processes are started when first needed and restarted after they fail.
+type UCIPool struct {
+	path string
+	// idle processes. A nil entry is a free slot without a running process.
+	procs chan *uciProcess
+}
+
+func NewUCIPool(path string, size int) *UCIPool {
+	pool := &UCIPool{
+		path:  path,
+		procs: make(chan *uciProcess, size),
+	}
+
+	for i := 0; i < size; i++ {
+		pool.procs <- nil
+	}
+
+	return pool
+}
+
+// Waits for a free engine process and asks it for a move
+func (pool *UCIPool) BestMove(ctx context.Context, req Request) (string, error) {
+	eval, err := pool.Evaluate(ctx, req)
+
+	return eval.BestMove, err
+}
+
+// Waits for a free engine process and has it search the position
+func (pool *UCIPool) Evaluate(ctx context.Context, req Request) (Evaluation, error) {
+	var proc *uciProcess
+
+	select {
+	case proc = <-pool.procs:
+	case <-ctx.Done():
+		return Evaluation{}, ctx.Err()
+	}
+
+	var err error
+
+	if proc == nil {
+		if proc, err = startUCI(pool.path); err != nil {
+			pool.procs <- nil
+			return Evaluation{}, err
+		}
+	}
+
+	eval, err := proc.evaluate(ctx, req)
+
+	// the engine may still be searching or be stuck, start over with a fresh process
+	if err != nil {
+		log.Printf("restarting engine %v after error: %v", pool.path, err)
+		proc.close()
+		proc = nil
+	}
+
+	pool.procs <- proc
+
+	return eval, err
+}
@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long an engine has to start up and answer isready
+const handshakeTimeout = 10 * time.Second
+
+// uciProcess is a running engine process spoken to over the UCI protocol
+type uciProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	// lines written by the engine, closed when it exits
+	lines chan string
+}
+
+func startUCI(path string) (*uciProcess, error) {
+	cmd := exec.Command(path)
+
+	stdin, err := cmd.StdinPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting engine %v: %w", path, err)
+	}
+
+	p := &uciProcess{
+		cmd:   cmd,
+		stdin: stdin,
+		lines: make(chan string, 64),
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+
+		for scanner.Scan() {
+			p.lines <- scanner.Text()
+		}
+
+		close(p.lines)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+
+	if err := p.send("uci"); err != nil {
+		p.close()
+		return nil, err
+	}
+
+	if _, err := p.waitFor(ctx, "uciok"); err != nil {
+		p.close()
+		return nil, fmt.Errorf("engine %v: %w", path, err)
+	}
+
+	return p, nil
+}
+
+func (p *uciProcess) send(commands ...string) error {
+	for _, command := range commands {
+		if _, err := io.WriteString(p.stdin, command+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reads lines until one starts with prefix and returns it
+func (p *uciProcess) waitFor(ctx context.Context, prefix string) (string, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case line, ok := <-p.lines:
+			if !ok {
+				return "", errors.New("engine exited")
+			}
+
+			if strings.HasPrefix(line, prefix) {
+				return line, nil
+			}
+		}
+	}
+}
+
+// Searches the position for req.MoveTime and returns the engine's move with the score
+// of the last search info it reported
+func (p *uciProcess) evaluate(ctx context.Context, req Request) (Evaluation, error) {
+	position := "position fen " + req.StartFEN
+
+	if len(req.Moves) > 0 {
+		position += " moves " + strings.Join(req.Moves, " ")
+	}
+
+	// engines that don't know an option ignore it
+	err := p.send(
+		fmt.Sprintf("setoption name UCI_Chess960 value %v", req.Chess960),
+		fmt.Sprintf("setoption name Skill Level value %v", req.Skill),
+		"isready",
+	)
+
+	if err != nil {
+		return Evaluation{}, err
+	}
+
+	if _, err := p.waitFor(ctx, "readyok"); err != nil {
+		return Evaluation{}, err
+	}
+
+	if err := p.send(position, fmt.Sprintf("go movetime %v", req.MoveTime.Milliseconds())); err != nil {
+		return Evaluation{}, err
+	}
+
+	var eval Evaluation
+
+	for {
+		line, err := p.waitFor(ctx, "")
+
+		if err != nil {
+			return Evaluation{}, err
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "info":
+			parseScore(fields, &eval)
+		case "bestmove":
+			if len(fields) < 2 || fields[1] == "(none)" {
+				return Evaluation{}, fmt.Errorf("engine found no move: %q", line)
+			}
+
+			eval.BestMove = fields[1]
+
+			return eval, nil
+		}
+	}
+}
+
+// Reads "score cp <n>" or "score mate <n>" from the fields of an info line
+func parseScore(fields []string, eval *Evaluation) {
+	for i := 0; i+2 < len(fields); i++ {
+		if fields[i] != "score" {
+			continue
+		}
+
+		n, err := strconv.Atoi(fields[i+2])
+
+		if err != nil {
+			return
+		}
+
+		switch fields[i+1] {
+		case "cp":
+			eval.Score, eval.Mate = n, 0
+		case "mate":
+			eval.Score, eval.Mate = 0, n
+		}
+
+		return
+	}
+}
+
+func (p *uciProcess) close() {
+	// keep reading so the engine isn't blocked writing output nobody waits for
+	go func() {
+		for range p.lines {
+		}
+	}()
+
+	p.send("quit")
+	p.stdin.Close()
+
+	// give the engine a moment to quit on its own
+	timer := time.AfterFunc(time.Second, func() {
+		p.cmd.Process.Kill()
+	})
+
+	p.cmd.Wait()
+	timer.Stop()
+}
@@ -0,0 +1,262 @@
+// Package fen parses and serialises Forsyth-Edwards Notation. It only deals with the
+// notation, whether a position makes sense is up to the chess package. Parsing is strict
+// and errors point at the offending field, so they can be shown to whoever sent the FEN.
+package fen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Board is a parsed FEN string
+type Board struct {
+	// piece letters indexed by square, a1 = 0, b1 = 1 ... h8 = 63. 0 for empty squares.
+	Placement [64]byte
+	// 'w' or 'b'
+	Turn byte
+	// castling availability as written, "-" if none. Besides KQkq this can hold
+	// rook files (Shredder-FEN/X-FEN) for chess960.
+	Castling string
+	// en passant target square, "-" if none
+	EnPassant      string
+	HalfmoveClock  int
+	FullmoveNumber int
+}
+
+// FEN fields, as reported by Error
+const (
+	FieldFEN            = "fen"
+	FieldPlacement      = "placement"
+	FieldTurn           = "turn"
+	FieldCastling       = "castling"
+	FieldEnPassant      = "en_passant"
+	FieldHalfmoveClock  = "halfmove_clock"
+	FieldFullmoveNumber = "fullmove_number"
+)
+
+// Error is a syntax error in one of the fields of a FEN string
+type Error struct {
+	Field string
+	// the offending field as written
+	Value  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if e.Field == FieldFEN {
+		return fmt.Sprintf("fen %q: %v", e.Value, e.Reason)
+	}
+
+	return fmt.Sprintf("fen %v %q: %v", e.Field, e.Value, e.Reason)
+}
+
+func fieldError(field, value, format string, args ...any) *Error {
+	return &Error{Field: field, Value: value, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Parses a FEN string with its six fields separated by single spaces. Returns an *Error naming
+// the offending field if the notation is malformed.
+func Parse(s string) (*Board, error) {
+	fields := strings.Split(s, " ")
+
+	if len(fields) != 6 {
+		return nil, fieldError(FieldFEN, s, "must have 6 fields separated by single spaces, got %v", len(fields))
+	}
+
+	b := &Board{}
+
+	if err := b.parsePlacement(fields[0]); err != nil {
+		return nil, err
+	}
+
+	if fields[1] != "w" && fields[1] != "b" {
+		return nil, fieldError(FieldTurn, fields[1], "must be w or b")
+	}
+
+	b.Turn = fields[1][0]
+
+	if err := parseCastling(fields[2]); err != nil {
+		return nil, err
+	}
+
+	b.Castling = fields[2]
+
+	if err := parseEnPassant(fields[3]); err != nil {
+		return nil, err
+	}
+
+	b.EnPassant = fields[3]
+
+	var err error
+
+	if b.HalfmoveClock, err = parseCounter(FieldHalfmoveClock, fields[4], 0); err != nil {
+		return nil, err
+	}
+
+	if b.FullmoveNumber, err = parseCounter(FieldFullmoveNumber, fields[5], 1); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *Board) parsePlacement(placement string) error {
+	ranks := strings.Split(placement, "/")
+
+	if len(ranks) != 8 {
+		return fieldError(FieldPlacement, placement, "must have 8 ranks, got %v", len(ranks))
+	}
+
+	for i, rank := range ranks {
+		// ranks are listed from the 8th down to the 1st
+		r := 7 - i
+		file := 0
+		lastDigit := false
+
+		for _, ch := range rank {
+			switch {
+			case ch >= '1' && ch <= '8':
+				if lastDigit {
+					return fieldError(FieldPlacement, placement, "rank %v has consecutive empty square counts", r+1)
+				}
+
+				file += int(ch - '0')
+				lastDigit = true
+			case strings.ContainsRune("pnbrqkPNBRQK", ch):
+				if file < 8 {
+					b.Placement[r*8+file] = byte(ch)
+				}
+
+				file++
+				lastDigit = false
+			default:
+				return fieldError(FieldPlacement, placement, "invalid character %q in rank %v", ch, r+1)
+			}
+		}
+
+		if file != 8 {
+			return fieldError(FieldPlacement, placement, "rank %v has %v squares instead of 8", r+1, file)
+		}
+	}
+
+	return nil
+}
+
+// Checks castling availability is "-" or up to two rights per colour, written as KQkq
+// or as rook files, without repeats
+func parseCastling(castling string) error {
+	if castling == "-" {
+		return nil
+	}
+
+	if castling == "" || len(castling) > 4 {
+		return fieldError(FieldCastling, castling, "must be - or 1 to 4 castling rights")
+	}
+
+	var perColor [2]int
+
+	for i := 0; i < len(castling); i++ {
+		ch := castling[i]
+
+		switch {
+		case ch == 'K' || ch == 'Q' || (ch >= 'A' && ch <= 'H'):
+			perColor[0]++
+		case ch == 'k' || ch == 'q' || (ch >= 'a' && ch <= 'h'):
+			perColor[1]++
+		default:
+			return fieldError(FieldCastling, castling, "invalid castling right %q", ch)
+		}
+
+		if strings.IndexByte(castling[:i], ch) >= 0 {
+			return fieldError(FieldCastling, castling, "castling right %q is repeated", ch)
+		}
+	}
+
+	if perColor[0] > 2 || perColor[1] > 2 {
+		return fieldError(FieldCastling, castling, "each colour has at most 2 castling rights")
+	}
+
+	return nil
+}
+
+func parseEnPassant(enPassant string) error {
+	if enPassant == "-" {
+		return nil
+	}
+
+	if len(enPassant) != 2 || enPassant[0] < 'a' || enPassant[0] > 'h' || (enPassant[1] != '3' && enPassant[1] != '6') {
+		return fieldError(FieldEnPassant, enPassant, "must be - or a square on rank 3 or 6")
+	}
+
+	return nil
+}
+
+// Parses a move counter written as a plain decimal number no smaller than min
+func parseCounter(field, value string, min int) (int, error) {
+	n, err := strconv.Atoi(value)
+
+	if err != nil || strings.TrimLeft(value, "0123456789") != "" {
+		return 0, fieldError(field, value, "must be a whole number")
+	}
+
+	if n < min {
+		return 0, fieldError(field, value, "must be at least %v", min)
+	}
+
+	return n, nil
+}
+
+func (b *Board) String() string {
+	var sb strings.Builder
+
+	for r := 7; r >= 0; r-- {
+		empty := 0
+
+		for f := 0; f < 8; f++ {
+			piece := b.Placement[r*8+f]
+
+			if piece == 0 {
+				empty++
+				continue
+			}
+
+			if empty > 0 {
+				sb.WriteByte(byte('0' + empty))
+				empty = 0
+			}
+
+			sb.WriteByte(piece)
+		}
+
+		if empty > 0 {
+			sb.WriteByte(byte('0' + empty))
+		}
+
+		if r > 0 {
+			sb.WriteByte('/')
+		}
+	}
+
+	castling := b.Castling
+
+	if castling == "" {
+		castling = "-"
+	}
+
+	enPassant := b.EnPassant
+
+	if enPassant == "" {
+		enPassant = "-"
+	}
+
+	fmt.Fprintf(&sb, " %c %v %v %v %v", b.Turn, castling, enPassant, b.HalfmoveClock, b.FullmoveNumber)
+
+	return sb.String()
+}
+
+// Returns the piece placement field only, which identifies the arrangement of the board
+func (b *Board) PlacementString() string {
+	s := b.String()
+	return s[:strings.IndexByte(s, ' ')]
+}
@@ -0,0 +1,85 @@
+package fen
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		field string
+		// part of the reason
+		reason string
+	}{
+		{"too few fields", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0", FieldFEN, "must have 6 fields"},
+		{"too many fields", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 1", FieldFEN, "must have 6 fields"},
+		{"double space", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR  w KQkq - 0 1", FieldFEN, "must have 6 fields"},
+		{"empty", "", FieldFEN, "must have 6 fields"},
+
+		{"seven ranks", "rnbqkbnr/pppppppp/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", FieldPlacement, "must have 8 ranks"},
+		{"short rank", "rnbqkbnr/pppppppp/8/8/7/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", FieldPlacement, "rank 4 has 7 squares"},
+		{"long rank", "rnbqkbnr/ppppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", FieldPlacement, "rank 7 has 9 squares"},
+		{"long rank of digits", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBN2 w KQkq - 0 1", FieldPlacement, "rank 1 has 9 squares"},
+		{"consecutive digits", "rnbqkbnr/pppppppp/44/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", FieldPlacement, "rank 6 has consecutive empty square counts"},
+		{"invalid piece", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNX w KQkq - 0 1", FieldPlacement, "invalid character 'X' in rank 1"},
+		{"zero", "rnbqkbnr/pppppppp/8/8/0p7/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", FieldPlacement, "invalid character '0' in rank 4"},
+
+		{"bad turn", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR W KQkq - 0 1", FieldTurn, "must be w or b"},
+
+		{"empty castling", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w  - 0 1", FieldCastling, "must be - or 1 to 4 castling rights"},
+		{"excess castling", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkqA - 0 1", FieldCastling, "must be - or 1 to 4 castling rights"},
+		{"three rights for one colour", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQAk - 0 1", FieldCastling, "each colour has at most 2 castling rights"},
+		{"repeated castling", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KKq - 0 1", FieldCastling, "castling right 'K' is repeated"},
+		{"invalid castling", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KX - 0 1", FieldCastling, "invalid castling right 'X'"},
+
+		{"en passant on rank 4", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e4 0 1", FieldEnPassant, "rank 3 or 6"},
+		{"en passant off the board", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq i3 0 1", FieldEnPassant, "rank 3 or 6"},
+		{"en passant too long", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e33 0 1", FieldEnPassant, "rank 3 or 6"},
+
+		{"non-numeric halfmove clock", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - x 1", FieldHalfmoveClock, "must be a whole number"},
+		{"signed halfmove clock", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - +1 1", FieldHalfmoveClock, "must be a whole number"},
+		{"negative halfmove clock", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - -1 1", FieldHalfmoveClock, "must be a whole number"},
+		{"non-numeric fullmove number", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 one", FieldFullmoveNumber, "must be a whole number"},
+		{"zero fullmove number", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 0", FieldFullmoveNumber, "must be at least 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.fen)
+
+			var fenErr *Error
+
+			if !errors.As(err, &fenErr) {
+				t.Fatalf("Parse(%q) = %v, want an *Error", tt.fen, err)
+			}
+
+			if fenErr.Field != tt.field || !strings.Contains(fenErr.Reason, tt.reason) {
+				t.Errorf("Parse(%q) = %+v, want field %v with a reason containing %q", tt.fen, fenErr, tt.field, tt.reason)
+			}
+		})
+	}
+}
+
+func TestParseString(t *testing.T) {
+	for _, s := range []string{
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1",
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+		"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9",
+		"4k3/8/8/8/8/8/8/4K3 b - - 99 150",
+	} {
+		b, err := Parse(s)
+
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", s, err)
+		}
+
+		if got := b.String(); got != s {
+			t.Errorf("Parse(%q).String() = %q", s, got)
+		}
+	}
+}
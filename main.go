@@ -5,7 +5,9 @@ import (
 	"log"
 
 	"github.com/judgegodwins/chess-server/api"
+	"github.com/judgegodwins/chess-server/telemetry"
 	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -18,12 +20,25 @@ func main() {
 		log.Fatal(err)
 	}
 
+	shutdownTracer, err := telemetry.InitTracer(context.Background(), config.TracingExporter, config.TracingServiceName)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer shutdownTracer(context.Background())
+
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     config.RedisAddress,
 		Password: config.RedisPassword,
 		DB:       0,
 	})
 
+	// create a span for every redis command
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		log.Fatal(err)
+	}
+
 	// check redis connection status
 	if err := rdb.Ping(context.Background()).Err(); err != nil {
 		log.Fatal(err)
@@ -31,5 +46,8 @@ func main() {
 
 	server := api.NewServer(config, rdb)
 
-	log.Fatal(server.Start())
+	if err := server.Start(); err != nil {
+		shutdownTracer(context.Background())
+		log.Fatal(err)
+	}
 }
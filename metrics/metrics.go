@@ -0,0 +1,12 @@
+package metrics
+
+import "expvar"
+
+// Counters exposed on /debug/vars
+var (
+	// rate limited requests and events, keyed by "ws:<event type>" or "http:<route>"
+	RateLimited = expvar.NewMap("rate_limited")
+
+	// requests rejected by the origin allowlist, keyed by "ws" or "http"
+	RejectedOrigins = expvar.NewMap("rejected_origins")
+)
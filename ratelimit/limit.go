@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultKey is the key whose limit applies to anything without a limit of its own
+const DefaultKey = "default"
+
+// Limit describes a token bucket refilled at Rate tokens per second and holding at most Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type Limits map[string]Limit
+
+// Returns the limit configured for key, falling back to the default limit
+func (l Limits) For(key string) (Limit, bool) {
+	if limit, ok := l[key]; ok {
+		return limit, true
+	}
+
+	limit, ok := l[DefaultKey]
+
+	return limit, ok
+}
+
+// Returns the key whose limit applies to key, which is DefaultKey unless key has a limit of its own.
+// Callers keying buckets by it can't be made to create a bucket per arbitrary key.
+func (l Limits) Key(key string) string {
+	if _, ok := l[key]; ok {
+		return key
+	}
+
+	return DefaultKey
+}
+
+// ParseLimits parses a comma separated list of key=rate:burst pairs,
+// e.g. "default=20:40,piece_move=5:10,join_room=0.5:5"
+func ParseLimits(spec string) (Limits, error) {
+	limits := make(Limits)
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+
+		if item == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(item, "=")
+
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid rate limit %q: expected key=rate:burst", item)
+		}
+
+		rateStr, burstStr, ok := strings.Cut(value, ":")
+
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: expected key=rate:burst", item)
+		}
+
+		rate, err := strconv.ParseFloat(rateStr, 64)
+
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate in rate limit %q", item)
+		}
+
+		burst, err := strconv.Atoi(burstStr)
+
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid burst in rate limit %q", item)
+		}
+
+		limits[strings.TrimSpace(key)] = Limit{Rate: rate, Burst: burst}
+	}
+
+	return limits, nil
+}
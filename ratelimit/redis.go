@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Refills the bucket stored in KEYS[1] based on the time elapsed since it was last touched
+// and takes a token from it. Returns whether a token was taken and, if not, how many
+// milliseconds until one is available.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+local retry = 0
+
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+
+return {allowed, retry}
+`)
+
+type Result struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// RedisLimiter keeps token buckets in redis so limits are shared by every server instance
+type RedisLimiter struct {
+	rdb *redis.Client
+}
+
+func NewRedisLimiter(rdb *redis.Client) *RedisLimiter {
+	return &RedisLimiter{rdb: rdb}
+}
+
+// Takes a token from the bucket identified by key
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	res, err := tokenBucketScript.Run(ctx, l.rdb, []string{GetBucketKey(key)}, limit.Rate, limit.Burst).Int64Slice()
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	if len(res) != 2 {
+		return Result{}, fmt.Errorf("unexpected token bucket script result: %v", res)
+	}
+
+	return Result{
+		Allowed:    res[0] == 1,
+		RetryAfter: time.Duration(res[1]) * time.Millisecond,
+	}, nil
+}
+
+func GetBucketKey(key string) string {
+	return fmt.Sprintf("ratelimit:%v", key)
+}
@@ -13,6 +13,10 @@ import (
 type Payload struct {
 	ID  string `json:"id"`
 	Username string `json:"username"`
+	// zero if the token never expires
+	ExpiresAt time.Time `json:"expires_at"`
+	// the user's rating if the token issuer rates players, zero if unrated
+	Rating int `json:"rating,omitempty"`
 }
 
 func NewJWTToken(claims jwt.MapClaims, secret []byte) (string, error) {
@@ -53,10 +57,25 @@ func ParseJWTToken(tokenString string, secret []byte) (*Payload, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	exp, err := claims.GetExpirationTime()
+
+	if err != nil {
+		return nil, err
+	}
+
 	payload := &Payload{
 		Username: username,
 		ID: id,
 	}
 
+	if exp != nil {
+		payload.ExpiresAt = exp.Time
+	}
+
+	// numeric claims are decoded as float64
+	if rating, ok := claims["rating"].(float64); ok {
+		payload.Rating = int(rating)
+	}
+
 	return payload, nil
 }
@@ -0,0 +1,17 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Generates a random one-time ticket used to authenticate a websocket upgrade
+func NewTicket() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
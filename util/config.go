@@ -1,9 +1,20 @@
 package util
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
+	"github.com/judgegodwins/chess-server/ratelimit"
+)
+
+const (
+	DefaultEventRateLimits = "default=20:40,piece_move=5:10,join_room=1:5,create_challenge=0.2:5,challenge_user=0.2:5"
+	DefaultHTTPRateLimits  = "token=0.2:5,rooms=1:10,moves=5:10"
+	DefaultAllowedOrigins  = "http://localhost:8080"
 )
 
 type Config struct {
@@ -11,6 +22,47 @@ type Config struct {
 	RedisAddress  string `mapstructure:"REDIS_ADDR" validate:"required"`
 	RedisPassword string `mapstructure:"REDIS_PW"`
 	Port          string `mapstructure:"PORT" validate:"required,number"`
+
+	TracingExporter    string `mapstructure:"TRACING_EXPORTER" validate:"omitempty,oneof=none otlp stdout"`
+	TracingServiceName string `mapstructure:"OTEL_SERVICE_NAME"`
+
+	// token bucket limits per websocket event type, applied per connection and per user
+	EventRateLimits ratelimit.Limits `mapstructure:"RATE_LIMIT_EVENTS"`
+	// token bucket limits per route group, applied per client IP
+	HTTPRateLimits ratelimit.Limits `mapstructure:"RATE_LIMIT_HTTP"`
+	// comma separated IPs or CIDRs of reverse proxies whose X-Forwarded-For header is trusted
+	// for the client IP. None by default, so clients can't pick the IP they are limited by.
+	TrustedProxies []string `mapstructure:"TRUSTED_PROXIES"`
+	// address of a separate listener serving /debug/vars, e.g. 127.0.0.1:6060. Disabled if empty.
+	AdminAddress string `mapstructure:"ADMIN_ADDR"`
+
+	// comma separated origins allowed to open websockets and call the REST api.
+	// Entries can be exact (https://chess.example.com), wildcard subdomains
+	// (https://*.example.com) or "*". Pages served by this server need their own origin listed.
+	AllowedOrigins *OriginMatcher `mapstructure:"ALLOWED_ORIGINS"`
+
+	WSReadBufferSize  int `mapstructure:"WS_READ_BUFFER_SIZE" validate:"min=1"`
+	WSWriteBufferSize int `mapstructure:"WS_WRITE_BUFFER_SIZE" validate:"min=1"`
+	// maximum size in bytes of a message read from a client
+	WSReadLimit int64 `mapstructure:"WS_READ_LIMIT" validate:"min=1"`
+	// negotiate permessage-deflate with clients that support it
+	WSCompression bool `mapstructure:"WS_COMPRESSION"`
+	// flate compression level, from -2 (huffman only) to 9 (best compression)
+	WSCompressionLevel int `mapstructure:"WS_COMPRESSION_LEVEL" validate:"min=-2,max=9"`
+	// messages smaller than this many bytes are sent uncompressed
+	WSCompressionThreshold int `mapstructure:"WS_COMPRESSION_THRESHOLD" validate:"min=0"`
+
+	// path of a UCI engine binary (e.g. stockfish) bots play with. Bots use the
+	// built-in engine if it's empty.
+	EnginePath string `mapstructure:"ENGINE_PATH"`
+	// number of engine processes bots share, or searches the built-in engine runs at once
+	EnginePoolSize int `mapstructure:"ENGINE_POOL_SIZE" validate:"min=1"`
+	// milliseconds bots think per move unless a room asks for something else
+	EngineMoveTime int `mapstructure:"ENGINE_MOVE_TIME" validate:"min=1"`
+	// depth the built-in engine searches at the highest skill level
+	EngineDepth int `mapstructure:"ENGINE_DEPTH" validate:"min=1,max=8"`
+	// spectator event streams served at once, each holds a redis connection of its own
+	SpectatorStreams int `mapstructure:"SPECTATOR_STREAMS" validate:"min=1"`
 }
 
 // func LoadConfigViper(path string) (*Config, error) {
@@ -52,6 +104,93 @@ func LoadConfig() (*Config, error) {
 		RedisAddress:  os.Getenv("REDIS_ADDR"),
 		Port:          os.Getenv("PORT"),
 		RedisPassword: os.Getenv("REDIS_PW"),
+
+		TracingExporter:    os.Getenv("TRACING_EXPORTER"),
+		TracingServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+	}
+
+	if config.TracingServiceName == "" {
+		config.TracingServiceName = "chess-server"
+	}
+
+	var err error
+
+	config.EventRateLimits, err = ratelimit.ParseLimits(getEnvDefault("RATE_LIMIT_EVENTS", DefaultEventRateLimits))
+
+	if err != nil {
+		return nil, fmt.Errorf("RATE_LIMIT_EVENTS: %w", err)
+	}
+
+	config.HTTPRateLimits, err = ratelimit.ParseLimits(getEnvDefault("RATE_LIMIT_HTTP", DefaultHTTPRateLimits))
+
+	if err != nil {
+		return nil, fmt.Errorf("RATE_LIMIT_HTTP: %w", err)
+	}
+
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		config.TrustedProxies = strings.Split(proxies, ",")
+	}
+
+	for _, proxy := range config.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: invalid IP or CIDR %q", proxy)
+			}
+		}
+	}
+
+	config.AdminAddress = os.Getenv("ADMIN_ADDR")
+
+	config.AllowedOrigins, err = NewOriginMatcher(strings.Split(getEnvDefault("ALLOWED_ORIGINS", DefaultAllowedOrigins), ","))
+
+	if err != nil {
+		return nil, fmt.Errorf("ALLOWED_ORIGINS: %w", err)
+	}
+
+	if config.WSReadBufferSize, err = getEnvInt("WS_READ_BUFFER_SIZE", 1024); err != nil {
+		return nil, err
+	}
+
+	if config.WSWriteBufferSize, err = getEnvInt("WS_WRITE_BUFFER_SIZE", 1024); err != nil {
+		return nil, err
+	}
+
+	readLimit, err := getEnvInt("WS_READ_LIMIT", 512)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.WSReadLimit = int64(readLimit)
+
+	if config.WSCompression, err = getEnvBool("WS_COMPRESSION", true); err != nil {
+		return nil, err
+	}
+
+	if config.WSCompressionLevel, err = getEnvInt("WS_COMPRESSION_LEVEL", 1); err != nil {
+		return nil, err
+	}
+
+	if config.WSCompressionThreshold, err = getEnvInt("WS_COMPRESSION_THRESHOLD", 256); err != nil {
+		return nil, err
+	}
+
+	config.EnginePath = os.Getenv("ENGINE_PATH")
+
+	if config.EnginePoolSize, err = getEnvInt("ENGINE_POOL_SIZE", 2); err != nil {
+		return nil, err
+	}
+
+	if config.EngineMoveTime, err = getEnvInt("ENGINE_MOVE_TIME", 1000); err != nil {
+		return nil, err
+	}
+
+	if config.EngineDepth, err = getEnvInt("ENGINE_DEPTH", 4); err != nil {
+		return nil, err
+	}
+
+	if config.SpectatorStreams, err = getEnvInt("SPECTATOR_STREAMS", 100); err != nil {
+		return nil, err
 	}
 
 	if err := Validate.Struct(config); err != nil {
@@ -60,3 +199,44 @@ func LoadConfig() (*Config, error) {
 
 	return config, nil
 }
+
+// Returns the value of the environment variable or fallback if it isn't set
+func getEnvDefault(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+	v, ok := os.LookupEnv(key)
+
+	if !ok || v == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(v)
+
+	if err != nil {
+		return 0, fmt.Errorf("%v: %w", key, err)
+	}
+
+	return n, nil
+}
+
+func getEnvBool(key string, fallback bool) (bool, error) {
+	v, ok := os.LookupEnv(key)
+
+	if !ok || v == "" {
+		return fallback, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+
+	if err != nil {
+		return false, fmt.Errorf("%v: %w", key, err)
+	}
+
+	return b, nil
+}
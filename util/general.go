@@ -1,6 +1,9 @@
 package util
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 const (
 	RoomIDKey              = "id"
@@ -10,8 +13,103 @@ const (
 	RoomPlayer2Key         = "player2"
 	RoomGameStateKey       = "game_state"
 	RoomGameStartedKey     = "active"
+	RoomPlayer1ColorKey    = "player1_color"
+	RoomResultKey          = "result"
+	RoomTerminationKey     = "termination"
+	RoomDrawOfferKey       = "draw_offer"
+	RoomModeKey            = "mode"
+	RoomDaysPerMoveKey     = "days_per_move"
+	RoomMoveDeadlineKey    = "move_deadline"
+	RoomVariantKey         = "variant"
+	RoomInitialFENKey      = "initial_fen"
+	// moves played so far in UCI notation, separated by spaces
+	RoomMovesKey = "moves"
+	// strength and thinking time in milliseconds of the bot in rooms against the computer
+	RoomBotSkillKey    = "bot_skill"
+	RoomBotMoveTimeKey = "bot_move_time"
+	// eco code and name of the opening the game reached most recently
+	RoomECOKey     = "eco"
+	RoomOpeningKey = "opening"
+	// player who offered a rematch after the game, and the id of the rematch's room once accepted
+	RoomRematchOfferKey = "rematch_offer"
+	RoomRematchKey      = "rematch"
+	// who can find and join the room, its bcrypt hashed join password and its short invite code
+	RoomVisibilityKey   = "visibility"
+	RoomPasswordHashKey = "password_hash"
+	RoomInviteCodeKey   = "invite_code"
+
+	// "true" when the first user asking to join is seated without the creator accepting
+	RoomAutoAcceptKey = "auto_accept"
+	// unix time archived games ended
+	GameEndedAtKey = "ended_at"
+)
+
+// player id of the bot in rooms against the computer
+const BotPlayerID = "bot"
+
+// Room modes
+const (
+	ModeLive           = "live"
+	ModeCorrespondence = "correspondence"
+)
+
+// sorted set of correspondence rooms scored by the unix time the player to move runs out of time
+const CorrespondenceDeadlinesKey = "correspondence:deadlines"
+
+const (
+	ColorWhite = "w"
+	ColorBlack = "b"
+)
+
+// Game results, as written in PGN
+const (
+	ResultWhiteWins = "1-0"
+	ResultBlackWins = "0-1"
+	ResultDraw      = "1/2-1/2"
 )
 
+// Reasons a game ended
+const (
+	TerminationResignation = "resignation"
+	TerminationAgreement   = "agreement"
+	TerminationTimeout     = "timeout"
+)
+
+// how long rooms are kept in redis
+const RoomTTL = 12 * time.Hour
+
+// how long finished games are archived, and their analysis kept
+const GameArchiveTTL = 30 * 24 * time.Hour
+
+// sorted set of the ids of public rooms waiting for an opponent, scored by the unix time they were created
+const PublicRoomsKey = "rooms:public"
+
+// how long a request to join a room waits for the creator to answer it
+const JoinRequestTTL = 2 * time.Minute
+
+// sorted set of pending join requests, as "<room id>:<user id>", scored by the unix time they expire
+const JoinRequestDeadlinesKey = "join_requests:deadlines"
+
+// how long an open challenge stays in the lobby without being accepted
+const ChallengeMaxAge = time.Hour
+
+// how long a challenge outlives the connection it was created from. The instance holding
+// the connection keeps refreshing it, so challenges of connections lost in a restart expire soon.
+const ChallengeTTL = 2 * time.Minute
+
+// how long a websocket connection counts as online without being refreshed. The instance holding
+// the connection keeps refreshing it, so users of connections lost in a restart go offline soon.
+const PresenceTTL = time.Minute
+
+// id of the room clients subscribed to the lobby join
+const LobbyRoomID = "lobby"
+
+// sorted set of the ids of open challenges in the lobby, scored by the unix time they were created
+const LobbyChallengesKey = "lobby:challenges"
+
+// how long a websocket ticket from POST /ws/ticket stays valid
+const WSTicketTTL = 30 * time.Second
+
 const DefaultFEN string = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
 
 type GameStartedEnum int
@@ -32,3 +130,56 @@ func (w GameStartedEnum) EnumIndex() int {
 func GetRoomKey(room string) string {
 	return fmt.Sprintf("room:%v", room)
 }
+
+// Redis stream of events emitted to a room, read by spectators
+func GetRoomEventsKey(room string) string {
+	return fmt.Sprintf("room:%v:events", room)
+}
+
+// Hash of the pending requests to join a room, from the requesting user's id to the JSON of the request
+func GetRoomJoinRequestsKey(room string) string {
+	return fmt.Sprintf("room:%v:join_requests", room)
+}
+
+// Hash of a finished game, with the game fields of its room and the time it ended. Games keep their room's id.
+func GetGameKey(game string) string {
+	return fmt.Sprintf("game:%v", game)
+}
+
+// JSON of the engine analysis of a finished game
+func GetGameAnalysisKey(game string) string {
+	return fmt.Sprintf("game:%v:analysis", game)
+}
+
+// Set of the correspondence rooms a user plays in
+func GetUserCorrespondenceKey(userID string) string {
+	return fmt.Sprintf("user:%v:correspondence", userID)
+}
+
+// Sorted set of the ids of a user's websocket connections on every instance, scored by the unix time they go offline
+func GetUserConnectionsKey(userID string) string {
+	return fmt.Sprintf("user:%v:connections", userID)
+}
+
+// Id of the room an invite code was created for
+func GetInviteKey(code string) string {
+	return fmt.Sprintf("invite:%v", code)
+}
+
+// JSON of an open challenge
+func GetChallengeKey(challenge string) string {
+	return fmt.Sprintf("challenge:%v", challenge)
+}
+
+// Set of the ids of the open challenges a user created, in the lobby or sent to another user
+func GetUserChallengesKey(userID string) string {
+	return fmt.Sprintf("user:%v:challenges", userID)
+}
+
+func GetWSTicketKey(ticket string) string {
+	return fmt.Sprintf("ws_ticket:%v", ticket)
+}
+
+func GetTraceKey(userID, traceID string) string {
+	return fmt.Sprintf("trace:%v:%v", userID, traceID)
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// OriginMatcher checks request origins against an allowlist of exact origins
+// (https://chess.example.com), wildcard subdomains (https://*.example.com) or "*" for any origin
+type OriginMatcher struct {
+	any       bool
+	exact     map[string]bool
+	wildcards []originWildcard
+}
+
+type originWildcard struct {
+	scheme string
+	// host suffix including the leading dot, e.g. ".example.com"
+	suffix string
+	port   string
+}
+
+func NewOriginMatcher(patterns []string) (*OriginMatcher, error) {
+	m := &OriginMatcher{
+		exact: make(map[string]bool),
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+
+		if pattern == "" {
+			continue
+		}
+
+		if pattern == "*" {
+			m.any = true
+			continue
+		}
+
+		u, err := url.Parse(pattern)
+
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid origin %q: expected scheme://host[:port]", pattern)
+		}
+
+		host := strings.ToLower(u.Hostname())
+
+		if strings.HasPrefix(host, "*.") {
+			m.wildcards = append(m.wildcards, originWildcard{
+				scheme: strings.ToLower(u.Scheme),
+				suffix: host[1:],
+				port:   u.Port(),
+			})
+			continue
+		}
+
+		if strings.Contains(host, "*") {
+			return nil, fmt.Errorf("invalid origin %q: wildcards are only allowed as the leftmost label", pattern)
+		}
+
+		m.exact[normalizeOrigin(u)] = true
+	}
+
+	return m, nil
+}
+
+// Reports whether every origin is allowed
+func (m *OriginMatcher) AllowsAny() bool {
+	return m.any
+}
+
+// Reports whether a request from origin is allowed
+func (m *OriginMatcher) Allowed(origin string) bool {
+	if m.any {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+
+	if m.exact[normalizeOrigin(u)] {
+		return true
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+
+	for _, w := range m.wildcards {
+		if w.scheme == scheme && w.port == u.Port() && strings.HasSuffix(host, w.suffix) && len(host) > len(w.suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeOrigin(u *url.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
@@ -0,0 +1,75 @@
+package util
+
+import "testing"
+
+func TestOriginMatcher(t *testing.T) {
+	m, err := NewOriginMatcher([]string{
+		"https://chess.example.com",
+		"http://localhost:3000",
+		"https://*.example.org",
+		"https://*.dev.example.net:8443",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://chess.example.com", true},
+		{"https://CHESS.example.com", true},
+		{"http://chess.example.com", false},
+		{"https://chess.example.com:8443", false},
+		{"https://evil-chess.example.com", false},
+		{"http://localhost:3000", true},
+		{"http://localhost", false},
+		{"http://localhost:3001", false},
+		{"https://play.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"http://play.example.org", false},
+		{"https://play.example.org:8443", false},
+		{"https://app.dev.example.net:8443", true},
+		{"https://app.dev.example.net", false},
+		{"null", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := m.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if m.AllowsAny() {
+		t.Error("AllowsAny() = true without a * pattern")
+	}
+}
+
+func TestOriginMatcherAny(t *testing.T) {
+	m, err := NewOriginMatcher([]string{"*"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.AllowsAny() || !m.Allowed("https://anything.example") {
+		t.Error("* should allow any origin")
+	}
+}
+
+func TestOriginMatcherInvalid(t *testing.T) {
+	for _, pattern := range []string{
+		"chess.example.com",
+		"https://",
+		"https://chess.example.com/path",
+		"https://chess.*.example.com",
+	} {
+		if _, err := NewOriginMatcher([]string{pattern}); err == nil {
+			t.Errorf("NewOriginMatcher(%q) succeeded, want an error", pattern)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"math/big"
+
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Room visibilities
+const (
+	// listed in GET /rooms while waiting for an opponent
+	VisibilityPublic = "public"
+	// anyone with the room's id or invite code can find it and ask to join, the default
+	VisibilityUnlisted = "unlisted"
+	// only the players and users with the invite code can find it and ask to join
+	VisibilityPrivate = "private"
+)
+
+var ErrWrongPassword = errors.New("wrong room password")
+
+// invite codes leave out characters that are easy to mix up, like 0 and O
+const (
+	inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	inviteCodeLength   = 8
+)
+
+func roomVisibility(room map[string]string) string {
+	if v := room[util.RoomVisibilityKey]; v != "" {
+		return v
+	}
+
+	return VisibilityUnlisted
+}
+
+// Reports whether userID may learn the room exists. Private rooms are only shown to
+// their players and to users who know their invite code. Anonymous users have an empty id.
+func CanSeeRoom(room map[string]string, userID, inviteCode string) bool {
+	// an empty id would match the free seat of a room waiting for an opponent
+	if _, ok := playerColor(room, userID); ok && userID != "" {
+		return true
+	}
+
+	if roomVisibility(room) != VisibilityPrivate {
+		return true
+	}
+
+	code := room[util.RoomInviteCodeKey]
+
+	return code != "" && subtle.ConstantTimeCompare([]byte(code), []byte(inviteCode)) == 1
+}
+
+// Checks the password a user sent to join the room, if the room has one
+func checkRoomPassword(room map[string]string, password string) error {
+	hash := room[util.RoomPasswordHashKey]
+
+	if hash == "" {
+		return nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+
+	return nil
+}
+
+func HashRoomPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// Generates a short random code that can be shared instead of a room's id
+func NewInviteCode() (string, error) {
+	code := make([]byte, inviteCodeLength)
+	max := big.NewInt(int64(len(inviteCodeAlphabet)))
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = inviteCodeAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// Returns the id of the room the invite code was created for, ErrRoomNotFound if there is none
+func RoomIDByInviteCode(ctx context.Context, rdb *redis.Client, code string) (string, error) {
+	roomID, err := rdb.Get(ctx, util.GetInviteKey(code)).Result()
+
+	if errors.Is(err, redis.Nil) {
+		return "", ErrRoomNotFound
+	}
+
+	return roomID, err
+}
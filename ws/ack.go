@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// how long handled trace ids are remembered to detect retries
+	traceDedupTTL = 5 * time.Minute
+	// how long a trace id stays claimed while its event is handled, so a claim left
+	// behind by a crashed instance doesn't block retries for long
+	tracePendingTTL = 30 * time.Second
+)
+
+var ErrTracePending = errors.New("an event with this trace id is still being handled, retry later")
+
+const (
+	tracePending = "pending"
+	traceDone    = "done"
+)
+
+// Reserves the event's trace id for the user so retried events are only handled once,
+// even if the retry arrives on another connection or instance. Returns the state the
+// trace id was already in, or an empty string if this is the first time it's seen.
+func (c *Client) claimTraceID(ctx context.Context, evt Event) (string, error) {
+	userID, _ := c.Data["userID"].(string)
+	key := util.GetTraceKey(userID, evt.TraceID)
+
+	claimed, err := c.manager.rdb.SetNX(ctx, key, tracePending, tracePendingTTL).Result()
+
+	if err != nil || claimed {
+		return "", err
+	}
+
+	state, err := c.manager.rdb.Get(ctx, key).Result()
+
+	if err == redis.Nil {
+		// expired in between, treat as new
+		return "", nil
+	}
+
+	return state, err
+}
+
+// Marks the event's trace id as handled
+func (c *Client) completeTraceID(ctx context.Context, evt Event) error {
+	userID, _ := c.Data["userID"].(string)
+
+	return c.manager.rdb.SetArgs(ctx, util.GetTraceKey(userID, evt.TraceID), traceDone, redis.SetArgs{
+		Mode: "XX",
+		TTL:  traceDedupTTL,
+	}).Err()
+}
+
+// Releases the event's trace id after it failed so the client can retry it
+func (c *Client) releaseTraceID(ctx context.Context, evt Event) error {
+	userID, _ := c.Data["userID"].(string)
+
+	return c.manager.rdb.Del(ctx, util.GetTraceKey(userID, evt.TraceID)).Err()
+}
+
+// Tells the client an event with the same trace id is still being handled
+func (c *Client) pushTracePending(ctx context.Context, evt Event) error {
+	errEvent, err := NewErrorEvent(evt.TraceID, ErrTracePending.Error())
+
+	if err != nil {
+		return err
+	}
+
+	errEvent.injectTraceContext(ctx)
+
+	c.PushToEgress(errEvent)
+
+	return nil
+}
+
+// Acknowledges a successfully handled event to the client that sent it
+func (c *Client) pushAck(ctx context.Context, evt Event, duplicate bool) error {
+	ack, err := NewEvent(EventAck, PayloadAck{
+		EventType: evt.Type,
+		Duplicate: duplicate,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	ack.TraceID = evt.TraceID
+	ack.injectTraceContext(ctx)
+
+	c.PushToEgress(ack)
+
+	return nil
+}
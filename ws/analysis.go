@@ -0,0 +1,387 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/judgegodwins/chess-server/chess"
+	"github.com/judgegodwins/chess-server/engine"
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrGameNotFound     = errors.New("game not found")
+	ErrAnalysisNotFound = errors.New("the game hasn't been analysed")
+)
+
+// Analysis statuses
+const (
+	AnalysisPending = "pending"
+	AnalysisRunning = "running"
+	AnalysisDone    = "done"
+	AnalysisFailed  = "failed"
+)
+
+// Move classifications, by how many centipawns the move lost compared to the engine's best move
+const (
+	ClassificationBlunder    = "blunder"
+	ClassificationMistake    = "mistake"
+	ClassificationInaccuracy = "inaccuracy"
+)
+
+// scores of mates in centipawns, and the cap applied to evaluations when measuring losses
+// so that, say, +15 and +20 count as equally winning
+const (
+	mateCentipawns = 10000
+	lossCap        = 1000
+)
+
+type MoveAnalysis struct {
+	Ply int `json:"ply"`
+	// the move played in UCI and standard algebraic notation
+	Move string `json:"move"`
+	SAN  string `json:"san"`
+	// evaluation after the move in centipawns from white's point of view
+	Eval int `json:"eval"`
+	// moves to mate after the move, positive if white mates
+	Mate int `json:"mate,omitempty"`
+	// the engine's move in the position the move was played in, in UCI notation
+	BestMove       string `json:"best_move"`
+	Classification string `json:"classification,omitempty" enum:"blunder,mistake,inaccuracy"`
+}
+
+type Analysis struct {
+	GameID   string         `json:"game_id"`
+	Status   string         `json:"status" enum:"pending,running,done,failed"`
+	Analyzed int            `json:"analyzed"`
+	Total    int            `json:"total"`
+	Moves    []MoveAnalysis `json:"moves"`
+	// unix time the analysis last made progress
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// Starts analysing a finished game userID played, unless it has been analysed or is being analysed already.
+// Returns the game's current analysis.
+func (m *Manager) RequestAnalysis(ctx context.Context, userID, gameID string) (*Analysis, error) {
+	game, err := m.rdb.HGetAll(ctx, util.GetGameKey(gameID)).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(game) == 0 {
+		return nil, ErrGameNotFound
+	}
+
+	if _, ok := playerColor(game, userID); !ok {
+		return nil, ErrNotAPlayer
+	}
+
+	analysis := &Analysis{
+		GameID: gameID,
+		Status: AnalysisPending,
+		Total:  len(strings.Fields(game[util.RoomMovesKey])),
+		Moves:  []MoveAnalysis{},
+		// counts as progress, the first evaluation can take a while
+		UpdatedAt: time.Now().Unix(),
+	}
+
+	existing, err := m.startAnalysis(ctx, analysis)
+
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	go m.analyzeGame(game, userID, analysis)
+
+	return analysis, nil
+}
+
+// Stores a new analysis unless the game has one that is done, or still making progress.
+// Failed and stale analyses are replaced so they can be retried. Returns the analysis
+// that was kept, or nil if the new one was stored and should be started.
+func (m *Manager) startAnalysis(ctx context.Context, analysis *Analysis) (*Analysis, error) {
+	key := util.GetGameAnalysisKey(analysis.GameID)
+
+	b, err := json.Marshal(analysis)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var existing *Analysis
+
+	txf := func(tx *redis.Tx) error {
+		existing, err = m.loadAnalysis(ctx, tx, analysis.GameID)
+
+		if err != nil && !errors.Is(err, ErrAnalysisNotFound) {
+			return err
+		}
+
+		if existing != nil && !m.retryableAnalysis(existing) {
+			return nil
+		}
+
+		existing = nil
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, b, util.GameArchiveTTL)
+
+			return nil
+		})
+
+		return err
+	}
+
+	err = m.rdb.Watch(ctx, txf, key)
+
+	// another request (re)started it first
+	if errors.Is(err, redis.TxFailedErr) {
+		return m.loadAnalysis(ctx, m.rdb, analysis.GameID)
+	}
+
+	return existing, err
+}
+
+// Reports whether the analysis can be started again, because it failed or because it hasn't
+// made progress for much longer than evaluating a position can take, like when the instance
+// running it stopped
+func (m *Manager) retryableAnalysis(analysis *Analysis) bool {
+	switch analysis.Status {
+	case AnalysisFailed:
+		return true
+	case AnalysisPending, AnalysisRunning:
+		staleAfter := 3 * (time.Duration(m.config.EngineMoveTime)*time.Millisecond + botMoveGrace)
+
+		return time.Since(time.Unix(analysis.UpdatedAt, 0)) > staleAfter
+	}
+
+	return false
+}
+
+// Returns the analysis of a finished game userID played
+func (m *Manager) GetAnalysis(ctx context.Context, userID, gameID string) (*Analysis, error) {
+	game, err := m.rdb.HMGet(ctx, util.GetGameKey(gameID),
+		util.RoomPlayer1Key, util.RoomPlayer2Key, util.RoomPlayer1ColorKey).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if game[0] == nil {
+		return nil, ErrGameNotFound
+	}
+
+	players := map[string]string{}
+
+	for i, field := range []string{util.RoomPlayer1Key, util.RoomPlayer2Key, util.RoomPlayer1ColorKey} {
+		players[field], _ = game[i].(string)
+	}
+
+	if _, ok := playerColor(players, userID); !ok {
+		return nil, ErrNotAPlayer
+	}
+
+	return m.loadAnalysis(ctx, m.rdb, gameID)
+}
+
+func (m *Manager) loadAnalysis(ctx context.Context, rdb redis.Cmdable, gameID string) (*Analysis, error) {
+	b, err := rdb.Get(ctx, util.GetGameAnalysisKey(gameID)).Bytes()
+
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrAnalysisNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var analysis Analysis
+
+	if err := json.Unmarshal(b, &analysis); err != nil {
+		return nil, err
+	}
+
+	return &analysis, nil
+}
+
+// Evaluates every position of the game, storing the analysis and telling the user
+// who asked for it about the progress after each move
+func (m *Manager) analyzeGame(game map[string]string, userID string, analysis *Analysis) {
+	ctx := context.Background()
+
+	replay, err := GameFromRoom(game)
+
+	if err == nil {
+		err = m.runAnalysis(ctx, replay, userID, analysis)
+	}
+
+	if err != nil {
+		log.Printf("error analysing game %v: %v", analysis.GameID, err)
+		analysis.Status = AnalysisFailed
+	} else {
+		analysis.Status = AnalysisDone
+	}
+
+	if err := m.saveAnalysis(ctx, userID, analysis); err != nil {
+		log.Printf("error saving analysis of game %v: %v", analysis.GameID, err)
+	}
+}
+
+func (m *Manager) runAnalysis(ctx context.Context, replay *chess.Game, userID string, analysis *Analysis) error {
+	analysis.Status = AnalysisRunning
+
+	// replay the game from the start, evaluating the position before each move
+	game, err := chess.NewGame(replay.Variant, replay.StartFEN)
+
+	if err != nil {
+		return err
+	}
+
+	before, err := m.evaluatePosition(ctx, game)
+
+	if err != nil {
+		return err
+	}
+
+	for i, move := range replay.Moves {
+		if err := game.Play(move); err != nil {
+			return err
+		}
+
+		after, err := m.evaluatePosition(ctx, game)
+
+		if err != nil {
+			return err
+		}
+
+		// engine scores are from the side to move's point of view
+		toWhite := 1
+
+		if game.Position.Turn == chess.Black {
+			toWhite = -1
+		}
+
+		moveAnalysis := MoveAnalysis{
+			Ply:      i + 1,
+			Move:     replay.UCIs[i],
+			SAN:      replay.SANs[i],
+			Eval:     after.Score * toWhite,
+			Mate:     after.Mate * toWhite,
+			BestMove: before.BestMove,
+			// the mover's score after the move is the opponent's negated
+			Classification: classifyLoss(capScore(before) + capScore(after)),
+		}
+
+		// the engine's own move loses nothing, whatever its search said afterwards
+		if before.BestMove == replay.UCIs[i] {
+			moveAnalysis.Classification = ""
+		}
+
+		analysis.Moves = append(analysis.Moves, moveAnalysis)
+		analysis.Analyzed++
+
+		if err := m.saveAnalysis(ctx, userID, analysis); err != nil {
+			return err
+		}
+
+		before = after
+	}
+
+	return nil
+}
+
+// Evaluates the game's current position, scoring finished games without asking the engine
+func (m *Manager) evaluatePosition(ctx context.Context, game *chess.Game) (engine.Evaluation, error) {
+	if len(game.Position.LegalMoves()) == 0 {
+		if game.Position.InCheck() {
+			return engine.Evaluation{Mate: -1}, nil
+		}
+
+		return engine.Evaluation{}, nil
+	}
+
+	moveTime := time.Duration(m.config.EngineMoveTime) * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(ctx, moveTime+botMoveGrace)
+	defer cancel()
+
+	return m.engine.Evaluate(ctx, engine.Request{
+		StartFEN: game.StartFEN,
+		Moves:    game.UCIs,
+		Chess960: game.Variant == chess.Chess960,
+		Skill:    engine.MaxSkill,
+		MoveTime: moveTime,
+	})
+}
+
+// Returns an evaluation in centipawns capped to lossCap, counting mates as the cap
+func capScore(eval engine.Evaluation) int {
+	score := eval.Score
+
+	switch {
+	case eval.Mate > 0:
+		score = mateCentipawns
+	case eval.Mate < 0:
+		score = -mateCentipawns
+	}
+
+	if score > lossCap {
+		return lossCap
+	}
+
+	if score < -lossCap {
+		return -lossCap
+	}
+
+	return score
+}
+
+func classifyLoss(loss int) string {
+	switch {
+	case loss >= 300:
+		return ClassificationBlunder
+	case loss >= 100:
+		return ClassificationMistake
+	case loss >= 50:
+		return ClassificationInaccuracy
+	}
+
+	return ""
+}
+
+// Stores the analysis and sends its progress to the user's connections
+func (m *Manager) saveAnalysis(ctx context.Context, userID string, analysis *Analysis) error {
+	analysis.UpdatedAt = time.Now().Unix()
+
+	b, err := json.Marshal(analysis)
+
+	if err != nil {
+		return err
+	}
+
+	if err := m.rdb.Set(ctx, util.GetGameAnalysisKey(analysis.GameID), b, util.GameArchiveTTL).Err(); err != nil {
+		return err
+	}
+
+	evt, err := NewEvent(EventAnalysisProgress, PayloadAnalysisProgress{
+		GameID:   analysis.GameID,
+		Status:   analysis.Status,
+		Analyzed: analysis.Analyzed,
+		Total:    analysis.Total,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// every client joins the room named after its user
+	m.EmitToRoom(userID, evt)
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/judgegodwins/chess-server/tokens"
+	"github.com/judgegodwins/chess-server/util"
+)
+
+// Browsers can't set headers on websocket requests, so clients pass their JWT as the
+// subprotocol following this one: new WebSocket(url, ["access_token", token])
+const AuthSubprotocol = "access_token"
+
+// how long a connection that didn't authenticate during the upgrade has to send an auth event
+var authTimeout = 10 * time.Second
+
+var errNoCredentials = errors.New("no credentials sent")
+
+type wsQuery struct {
+	// Deprecated: tokens in the query string leak into access logs and proxies.
+	// Use a ticket, the access_token subprotocol or an auth event instead.
+	Token string `form:"token"`
+	// one-time ticket from POST /ws/ticket
+	Ticket string `form:"ticket"`
+}
+
+// Authenticates a websocket upgrade request using, in order, the access_token subprotocol,
+// a one-time ticket or the deprecated token query parameter. Returns errNoCredentials if
+// none was sent, in which case the client must authenticate with its first message.
+func (m *Manager) authenticateRequest(c *gin.Context) (*tokens.Payload, http.Header, error) {
+	header := http.Header{}
+
+	if token, ok := subprotocolToken(c.Request); ok {
+		payload, err := tokens.ParseJWTToken(token, []byte(m.config.JWTSecret))
+		return payload, header, err
+	}
+
+	var query wsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		return nil, header, err
+	}
+
+	if query.Ticket != "" {
+		payload, err := m.redeemTicket(c, query.Ticket)
+		return payload, header, err
+	}
+
+	if query.Token != "" {
+		log.Printf("deprecated: websocket token sent in query string from %v", c.ClientIP())
+		header.Set("Deprecation", "true")
+
+		payload, err := tokens.ParseJWTToken(query.Token, []byte(m.config.JWTSecret))
+		return payload, header, err
+	}
+
+	return nil, header, errNoCredentials
+}
+
+// Returns the JWT sent after the access_token subprotocol
+func subprotocolToken(r *http.Request) (string, bool) {
+	protocols := websocket.Subprotocols(r)
+
+	for i, p := range protocols {
+		if p == AuthSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1], true
+		}
+	}
+
+	return "", false
+}
+
+// Exchanges a ticket for the auth payload it was issued to. Tickets can only be used once.
+func (m *Manager) redeemTicket(c *gin.Context, ticket string) (*tokens.Payload, error) {
+	b, err := m.rdb.GetDel(c.Request.Context(), util.GetWSTicketKey(ticket)).Bytes()
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid ticket: %w", err)
+	}
+
+	var payload tokens.Payload
+
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return nil, err
+	}
+
+	return &payload, nil
+}
+
+// Waits for the connection's first message, which must be an auth event carrying a valid token
+func (m *Manager) authenticateFirstMessage(conn *websocket.Conn, codec Codec) (*tokens.Payload, error) {
+	conn.SetReadLimit(m.config.WSReadLimit)
+
+	if err := conn.SetReadDeadline(time.Now().Add(authTimeout)); err != nil {
+		return nil, err
+	}
+
+	messageType, msg, err := conn.ReadMessage()
+
+	if err != nil {
+		return nil, err
+	}
+
+	evt, err := decodeFrame(codec, messageType, msg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if evt.Type != EventAuth {
+		return nil, fmt.Errorf("expected %v event, got %v", EventAuth, evt.Type)
+	}
+
+	var authPayload PayloadAuth
+
+	if err := json.Unmarshal(evt.Payload, &authPayload); err != nil {
+		return nil, err
+	}
+
+	payload, err := tokens.ParseJWTToken(authPayload.Token, []byte(m.config.JWTSecret))
+
+	if err != nil {
+		return nil, err
+	}
+
+	evt, err = NewEvent(EventAuthenticated, PayloadAuthenticated{
+		UserID:    payload.ID,
+		ExpiresAt: payload.ExpiresAt,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := codec.Encode(evt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the write goroutine isn't running yet so it's safe to write directly
+	if err := conn.WriteMessage(codec.MessageType(), data); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// Closes the connection with a policy violation close code
+func closePolicyViolation(conn *websocket.Conn, reason string) {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
@@ -0,0 +1,128 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/judgegodwins/chess-server/chess"
+	"github.com/judgegodwins/chess-server/engine"
+	"github.com/judgegodwins/chess-server/util"
+)
+
+const (
+	// time a bot gets on top of its move time before giving up on the engine
+	botMoveGrace = 10 * time.Second
+	// how many times the engine is asked for a move before the bot resigns.
+	// Engine pools restart a process that failed, so each attempt gets a fresh one.
+	botMoveAttempts = 2
+)
+
+func isBot(userID string) bool {
+	return userID == util.BotPlayerID
+}
+
+// Plays the bot's move if it is the bot's turn in the room. Called in its own goroutine
+// when a room against the computer is created and after every move of its opponent.
+func (m *Manager) PlayBotMove(roomID string) {
+	ctx := context.Background()
+
+	room, err := m.rdb.HGetAll(ctx, util.GetRoomKey(roomID)).Result()
+
+	if err != nil {
+		log.Printf("bot in room %v: error getting room: %v", roomID, err)
+		return
+	}
+
+	color, ok := playerColor(room, util.BotPlayerID)
+
+	if !ok || room[util.RoomResultKey] != "" {
+		return
+	}
+
+	game, err := GameFromRoom(room)
+
+	if err != nil {
+		log.Printf("bot in room %v: %v", roomID, err)
+		return
+	}
+
+	if game.Position.Turn.String() != color || game.Outcome() != nil {
+		return
+	}
+
+	skill, err := strconv.Atoi(room[util.RoomBotSkillKey])
+
+	if err != nil {
+		skill = engine.DefaultSkill
+	}
+
+	moveTime := time.Duration(m.config.EngineMoveTime) * time.Millisecond
+
+	if ms, err := strconv.Atoi(room[util.RoomBotMoveTimeKey]); err == nil {
+		moveTime = time.Duration(ms) * time.Millisecond
+	}
+
+	move, err := m.botBestMove(ctx, engine.Request{
+		StartFEN: game.StartFEN,
+		Moves:    game.UCIs,
+		Chess960: game.Variant == chess.Chess960,
+		Skill:    skill,
+		MoveTime: moveTime,
+	})
+
+	if err != nil {
+		log.Printf("bot in room %v: engine error, resigning: %v", roomID, err)
+
+		// don't leave the opponent waiting for a move that won't come
+		if _, err := m.Resign(ctx, util.BotPlayerID, roomID); err != nil {
+			log.Printf("bot in room %v: error resigning: %v", roomID, err)
+		}
+
+		return
+	}
+
+	uci, err := json.Marshal(move)
+
+	if err != nil {
+		log.Printf("bot in room %v: %v", roomID, err)
+		return
+	}
+
+	evt, err := NewEvent(EventPieceMove, PayloadPieceMove{
+		RoomID: roomID,
+		Move:   uci,
+	})
+
+	if err != nil {
+		log.Printf("bot in room %v: %v", roomID, err)
+		return
+	}
+
+	if _, err := m.SubmitMove(ctx, util.BotPlayerID, evt); err != nil {
+		log.Printf("bot in room %v: error playing %v: %v", roomID, move, err)
+	}
+}
+
+// Asks the engine for a move, trying again if it fails
+func (m *Manager) botBestMove(ctx context.Context, req engine.Request) (string, error) {
+	var err error
+
+	for attempt := 1; attempt <= botMoveAttempts; attempt++ {
+		var move string
+
+		attemptCtx, cancel := context.WithTimeout(ctx, req.MoveTime+botMoveGrace)
+		move, err = m.engine.BestMove(attemptCtx, req)
+		cancel()
+
+		if err == nil {
+			return move, nil
+		}
+
+		log.Printf("engine error on attempt %v of %v: %v", attempt, botMoveAttempts, err)
+	}
+
+	return "", err
+}
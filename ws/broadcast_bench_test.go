@@ -0,0 +1,185 @@
+package ws
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// Measures the effect of permessage-deflate on bytes sent and time taken for large events,
+// such as full move lists and chat history, over a loopback websocket connection.
+//
+//	go test ./ws -run '^$' -bench Broadcast
+func BenchmarkBroadcast(b *testing.B) {
+	payloads := []struct {
+		name string
+		evt  Event
+	}{
+		{"move list (300 plies)", benchMoveList(b, 300)},
+		{"chat history (200 messages)", benchChatHistory(b, 200)},
+	}
+
+	configs := []struct {
+		name        string
+		compression bool
+		level       int
+	}{
+		{"uncompressed", false, 0},
+		{"deflate level 1", true, 1},
+		{"deflate level 6", true, 6},
+		{"deflate level 9", true, 9},
+	}
+
+	for _, payload := range payloads {
+		for _, cfg := range configs {
+			b.Run(payload.name+"/"+cfg.name, func(b *testing.B) {
+				benchmarkBroadcast(b, payload.evt, cfg.compression, cfg.level)
+			})
+		}
+	}
+}
+
+// Sends the event b.N times from the server to a client, reporting the bytes written to the wire per event
+func benchmarkBroadcast(b *testing.B, evt Event, compression bool, level int) {
+	data, err := jsonCodec.Encode(evt)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var written atomic.Int64
+	ready := make(chan struct{})
+	done := make(chan struct{})
+
+	upgrader := websocket.Upgrader{EnableCompression: compression}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			b.Error(err)
+			return
+		}
+
+		defer conn.Close()
+
+		if compression {
+			if err := conn.SetCompressionLevel(level); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+
+		// don't count the handshake
+		<-ready
+		written.Store(0)
+
+		for i := 0; i < b.N; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+
+		<-done
+	}))
+
+	srv.Listener = countingListener{srv.Listener, &written}
+	srv.Start()
+	defer srv.Close()
+
+	dialer := websocket.Dialer{EnableCompression: compression}
+
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	close(ready)
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.StopTimer()
+	close(done)
+
+	b.ReportMetric(float64(written.Load())/float64(b.N), "wire-bytes/event")
+}
+
+// counts bytes written to the underlying connection
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+type countingListener struct {
+	net.Listener
+	written *atomic.Int64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return countingConn{conn, l.written}, nil
+}
+
+func benchEvent(b *testing.B, evtType string, payload any) Event {
+	evt, err := NewEvent(evtType, payload)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return evt
+}
+
+func benchMoveList(b *testing.B, plies int) Event {
+	sequence := []string{"e4", "e5", "Nf3", "Nc6", "Bb5", "a6", "Ba4", "Nf6", "O-O", "Be7", "Re1", "b5", "Bb3", "d6", "c3", "O-O"}
+	moves := make([]map[string]string, plies)
+
+	for i := range moves {
+		moves[i] = map[string]string{
+			"san": sequence[i%len(sequence)],
+			"fen": fmt.Sprintf("r1bq1rk1/2p1bppp/p1np1n2/1p2p3/4P3/1BP2N1P/PP1P1PP1/RNBQR1K1 b - - %d %d", i%50, i/2+1),
+		}
+	}
+
+	return benchEvent(b, "move_list", map[string]any{"room_id": "7f1c7ae2-8a55-4a5e-9c5b-1a3d58a7c2b1", "moves": moves})
+}
+
+func benchChatHistory(b *testing.B, count int) Event {
+	messages := make([]PayloadSendMessage, count)
+
+	for i := range messages {
+		messages[i] = PayloadSendMessage{
+			From:    fmt.Sprintf("player%d", i%2+1),
+			Message: fmt.Sprintf("message %d: good luck, have fun! nice opening, I didn't expect that", i),
+		}
+	}
+
+	return benchEvent(b, "chat_history", messages)
+}
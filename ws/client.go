@@ -2,21 +2,27 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/judgegodwins/chess-server/util"
 	"golang.org/x/exp/slices"
+	"golang.org/x/time/rate"
 )
 
 var (
 	pongWait     = 10 * time.Second
 	pingInterval = (pongWait * 9) / 10
+	// how many events can wait to be written before the client is considered too slow
+	egressBufferSize = 256
 )
 
+var errSlowClient = errors.New("client is not reading its events fast enough")
+
 type Client struct {
 	ID          string
 	connection  *websocket.Conn
@@ -25,6 +31,13 @@ type Client struct {
 	JoinedRooms []string
 	Data        map[string]interface{}
 	err         chan error
+	limiters    map[string]*rate.Limiter
+	// receives the expiry of a fresh token sent with a reauth event
+	reauth chan time.Time
+	// protocol version negotiated when connecting
+	Protocol string
+	// wire encoding negotiated when connecting
+	codec Codec
 }
 
 func NewClient(conn *websocket.Conn, manager *Manager) *Client {
@@ -32,16 +45,20 @@ func NewClient(conn *websocket.Conn, manager *Manager) *Client {
 		ID:          uuid.NewString(),
 		connection:  conn,
 		manager:     manager,
-		egress:      make(chan Event),
+		egress:      make(chan Event, egressBufferSize),
 		JoinedRooms: []string{},
 		Data:        make(map[string]interface{}),
-		err:         make(chan error),
+		err:         make(chan error, 1),
+		limiters:    make(map[string]*rate.Limiter),
+		reauth:      make(chan time.Time, 1),
+		Protocol:    LatestProtocol,
+		codec:       jsonCodec,
 	}
 }
 
 // Reads incoming messages from the clients websocket connection
 func (c *Client) readMessages(ctx context.Context) {
-	c.connection.SetReadLimit(512)
+	c.connection.SetReadLimit(c.manager.config.WSReadLimit)
 
 	if err := c.connection.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
 		c.handleError(err)
@@ -55,7 +72,7 @@ func (c *Client) readMessages(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		default:
-			_, payload, err := c.connection.ReadMessage()
+			messageType, payload, err := c.connection.ReadMessage()
 
 			if err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
@@ -65,16 +82,60 @@ func (c *Client) readMessages(ctx context.Context) {
 				return
 			}
 
-			var evt Event
+			evt, err := decodeFrame(c.codec, messageType, payload)
 
-			if err := json.Unmarshal(payload, &evt); err != nil {
+			if err != nil {
 				c.handleError(err)
 				return
 			}
 
-			if err := c.manager.routeEvent(ctx, evt, c); err != nil {
+			if ok, retryAfter := c.allowEvent(ctx, evt.Type); !ok {
+				if err := c.pushRateLimited(evt, retryAfter); err != nil {
+					c.handleError(err)
+					return
+				}
+				continue
+			}
+
+			// continue the client's trace if the event carries W3C trace context
+			evtCtx := evt.extractTraceContext(ctx)
+
+			if evt.TraceID != "" {
+				state, err := c.claimTraceID(evtCtx, evt)
+
+				if err != nil {
+					// handle the event anyway, a duplicate is better than dropping it
+					log.Printf("error claiming trace id %v: %v", evt.TraceID, err)
+				}
+
+				if state == traceDone {
+					// already handled, the client must have missed the ack
+					if err := c.pushAck(evtCtx, evt, true); err != nil {
+						c.handleError(err)
+						return
+					}
+					continue
+				}
+
+				if state == tracePending {
+					// still being handled, the client can retry once it's acked or the claim expires
+					if err := c.pushTracePending(evtCtx, evt); err != nil {
+						c.handleError(err)
+						return
+					}
+					continue
+				}
+			}
+
+			if err := c.manager.routeEvent(evtCtx, evt, c); err != nil {
 				log.Printf("error handling event %v: %v", evt, err)
 
+				if evt.TraceID != "" {
+					if err := c.releaseTraceID(evtCtx, evt); err != nil {
+						log.Printf("error releasing trace id %v: %v", evt.TraceID, err)
+					}
+				}
+
 				errEvent, err := NewErrorEvent(evt.TraceID, err.Error())
 
 				if err != nil {
@@ -82,9 +143,24 @@ func (c *Client) readMessages(ctx context.Context) {
 					return
 				}
 
+				errEvent.injectTraceContext(evtCtx)
+
 				c.PushToEgress(errEvent)
 				// emit an error to client. Any errors returned from event handlers
 				// should be emitted to the client using the trace id
+				continue
+			}
+
+			// acknowledge events the client can correlate
+			if evt.TraceID != "" {
+				if err := c.completeTraceID(evtCtx, evt); err != nil {
+					log.Printf("error completing trace id %v: %v", evt.TraceID, err)
+				}
+
+				if err := c.pushAck(evtCtx, evt, false); err != nil {
+					c.handleError(err)
+					return
+				}
 			}
 		}
 
@@ -110,14 +186,17 @@ func (c *Client) writeMessages(ctx context.Context) {
 				return
 			}
 
-			data, err := json.Marshal(message)
+			data, err := c.codec.Encode(message)
 
 			if err != nil {
 				c.handleError(err)
 				return
 			}
 
-			if err := c.connection.WriteMessage(websocket.TextMessage, data); err != nil {
+			// compressing small frames costs more than it saves
+			c.connection.EnableWriteCompression(len(data) >= c.manager.config.WSCompressionThreshold)
+
+			if err := c.connection.WriteMessage(c.codec.MessageType(), data); err != nil {
 				c.handleError(err)
 				return
 			}
@@ -137,9 +216,13 @@ func (c *Client) pongHandler(pongMsg string) error {
 
 // Push error to client error channel. This is used by the
 // http handler to know when an error has occurred in a client's readMessage or writeMessage goroutine.
-// The http handler closes the connection and removes the client when an error is pushed to the channel
+// The http handler closes the connection and removes the client when an error is pushed to the channel.
+// Only the first error is kept, the connection is already being closed when more arrive.
 func (c *Client) handleError(e error) {
-	c.err <- e
+	select {
+	case c.err <- e:
+	default:
+	}
 }
 
 // Returns the error channel
@@ -157,9 +240,26 @@ func (c *Client) PushEventToEgress(evtType string, payload any) error {
 	return nil
 }
 
-// Pushes an event to the client's egress to the delivered via the websocket connection
+// Pushes an event to the client's egress to the delivered via the websocket connection.
+// Never blocks, a client whose egress is full is disconnected instead.
 func (c *Client) PushToEgress(evt Event) {
-	c.egress <- evt
+	select {
+	case c.egress <- evt:
+	default:
+		log.Printf("dropping %v event for client %v: %v", evt.Type, c.ID, errSlowClient)
+		c.handleError(errSlowClient)
+	}
+}
+
+// Returns the id of the user the client authenticated as
+func (c *Client) userID() (string, error) {
+	userID, ok := c.Data["userID"].(string)
+
+	if !ok {
+		return "", fmt.Errorf("userID not found in Data map of client with id %v", c.ID)
+	}
+
+	return userID, nil
 }
 
 // Helper method to join a room
@@ -214,16 +314,14 @@ func (c *Client) Leave(roomId string) {
 }
 
 func (c *Client) LeaveAllRooms() {
-	for _, room := range c.JoinedRooms {
+	// Leave removes rooms from JoinedRooms as it goes
+	for _, room := range slices.Clone(c.JoinedRooms) {
 		c.Leave(room)
 	}
 }
 
 // Emits a user_disconnect event to all rooms, a user disconnecting user is part of
 func (c *Client) EmitDisconnect() error {
-	c.manager.RLock()
-	defer c.manager.RUnlock()
-
 	userID, ok := c.Data["userID"].(string)
 	if !ok {
 		return errors.New("userID could not be casted to a string")
@@ -244,9 +342,13 @@ func (c *Client) EmitDisconnect() error {
 		return err
 	}
 
+	leaving := []string{}
+
+	c.manager.RLock()
+
 	// iterate over client's joined rooms
 	for _, room := range c.JoinedRooms {
-		if room == userID { // don't send user_disconnect to user's room
+		if room == userID || room == util.LobbyRoomID { // don't send user_disconnect to user's room or the lobby
 			continue
 		}
 
@@ -262,9 +364,16 @@ func (c *Client) EmitDisconnect() error {
 		}
 
 		if leavingRoom {
-			c.manager.EmitToRoom(room, evt)
+			leaving = append(leaving, room)
 		}
 	}
 
+	c.manager.RUnlock()
+
+	// EmitToRoom takes the lock itself
+	for _, room := range leaving {
+		c.manager.EmitToRoom(room, evt)
+	}
+
 	return nil
 }
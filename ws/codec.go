@@ -0,0 +1,299 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Codec encodes events for the wire. Events are handled as JSON internally,
+// binary codecs convert the envelope and payload on the way in and out.
+type Codec interface {
+	Encode(evt Event) ([]byte, error)
+	Decode(data []byte) (Event, error)
+	// websocket message type frames are sent with
+	MessageType() int
+}
+
+var (
+	jsonCodec     Codec = JSONCodec{}
+	msgpackCodec  Codec = MsgpackCodec{}
+	protobufCodec Codec = ProtobufCodec{}
+)
+
+// JSONCodec sends events as JSON text frames
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(evt Event) ([]byte, error) {
+	return json.Marshal(evt)
+}
+
+func (JSONCodec) Decode(data []byte) (Event, error) {
+	var evt Event
+	err := json.Unmarshal(data, &evt)
+	return evt, err
+}
+
+func (JSONCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+// MsgpackCodec sends events as MessagePack maps with the same keys as the JSON envelope
+type MsgpackCodec struct{}
+
+type msgpackEvent struct {
+	Type        string             `msgpack:"type"`
+	TraceID     string             `msgpack:"trace_id"`
+	Payload     msgpack.RawMessage `msgpack:"payload"`
+	TraceParent string             `msgpack:"traceparent,omitempty"`
+	TraceState  string             `msgpack:"tracestate,omitempty"`
+}
+
+func (MsgpackCodec) Encode(evt Event) ([]byte, error) {
+	payload, err := decodeJSONValue(evt.Payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := msgpack.Marshal(payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return msgpack.Marshal(msgpackEvent{
+		Type:        evt.Type,
+		TraceID:     evt.TraceID,
+		Payload:     b,
+		TraceParent: evt.TraceParent,
+		TraceState:  evt.TraceState,
+	})
+}
+
+func (MsgpackCodec) Decode(data []byte) (Event, error) {
+	var m msgpackEvent
+
+	if err := msgpack.Unmarshal(data, &m); err != nil {
+		return Event{}, err
+	}
+
+	var payload any
+
+	if len(m.Payload) > 0 {
+		if err := msgpack.Unmarshal(m.Payload, &payload); err != nil {
+			return Event{}, err
+		}
+	}
+
+	b, err := json.Marshal(payload)
+
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		Type:        m.Type,
+		TraceID:     m.TraceID,
+		Payload:     b,
+		TraceParent: m.TraceParent,
+		TraceState:  m.TraceState,
+	}, nil
+}
+
+func (MsgpackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+// ProtobufCodec sends events as protobuf messages, see docs/protocol.proto.
+// Payloads are carried as a google.protobuf.Value so no per event messages are needed.
+type ProtobufCodec struct{}
+
+// field numbers of the Event message in docs/protocol.proto
+const (
+	protoFieldType        protowire.Number = 1
+	protoFieldTraceID     protowire.Number = 2
+	protoFieldPayload     protowire.Number = 3
+	protoFieldTraceParent protowire.Number = 4
+	protoFieldTraceState  protowire.Number = 5
+)
+
+func (ProtobufCodec) Encode(evt Event) ([]byte, error) {
+	v, err := decodeJSONValue(evt.Payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := structpb.NewValue(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := proto.Marshal(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+
+	b = appendProtoString(b, protoFieldType, evt.Type)
+	b = appendProtoString(b, protoFieldTraceID, evt.TraceID)
+	b = protowire.AppendTag(b, protoFieldPayload, protowire.BytesType)
+	b = protowire.AppendBytes(b, payload)
+	b = appendProtoString(b, protoFieldTraceParent, evt.TraceParent)
+	b = appendProtoString(b, protoFieldTraceState, evt.TraceState)
+
+	return b, nil
+}
+
+func (ProtobufCodec) Decode(data []byte) (Event, error) {
+	var evt Event
+	var payload any
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+
+		if n < 0 {
+			return Event{}, protowire.ParseError(n)
+		}
+
+		data = data[n:]
+
+		if typ != protowire.BytesType {
+			// skip unknown fields
+			n = protowire.ConsumeFieldValue(num, typ, data)
+
+			if n < 0 {
+				return Event{}, protowire.ParseError(n)
+			}
+
+			data = data[n:]
+			continue
+		}
+
+		v, n := protowire.ConsumeBytes(data)
+
+		if n < 0 {
+			return Event{}, protowire.ParseError(n)
+		}
+
+		data = data[n:]
+
+		switch num {
+		case protoFieldType:
+			evt.Type = string(v)
+		case protoFieldTraceID:
+			evt.TraceID = string(v)
+		case protoFieldTraceParent:
+			evt.TraceParent = string(v)
+		case protoFieldTraceState:
+			evt.TraceState = string(v)
+		case protoFieldPayload:
+			var value structpb.Value
+
+			if err := proto.Unmarshal(v, &value); err != nil {
+				return Event{}, err
+			}
+
+			payload = value.AsInterface()
+		}
+	}
+
+	b, err := json.Marshal(payload)
+
+	if err != nil {
+		return Event{}, err
+	}
+
+	evt.Payload = b
+
+	return evt, nil
+}
+
+func (ProtobufCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	// proto3 doesn't encode empty strings
+	if s == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+
+	return protowire.AppendString(b, s)
+}
+
+// Decodes a JSON payload into plain Go values, keeping integers as int64
+// so binary encodings don't turn them into floats
+func decodeJSONValue(raw json.RawMessage) (any, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+
+	var v any
+
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return convertJSONNumbers(v)
+}
+
+func convertJSONNumbers(v any) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+
+		return v.Float64()
+	case map[string]any:
+		for k, item := range v {
+			converted, err := convertJSONNumbers(item)
+
+			if err != nil {
+				return nil, err
+			}
+
+			v[k] = converted
+		}
+	case []any:
+		for i, item := range v {
+			converted, err := convertJSONNumbers(item)
+
+			if err != nil {
+				return nil, err
+			}
+
+			v[i] = converted
+		}
+	}
+
+	return v, nil
+}
+
+var errUnexpectedFrame = errors.New("unexpected websocket frame type")
+
+// Decodes a frame read from the connection
+func decodeFrame(codec Codec, messageType int, data []byte) (Event, error) {
+	if messageType != codec.MessageType() {
+		return Event{}, fmt.Errorf("%w: %v", errUnexpectedFrame, messageType)
+	}
+
+	return codec.Decode(data)
+}
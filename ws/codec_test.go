@@ -0,0 +1,97 @@
+package ws
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	move, err := NewEvent(EventPieceMove, PayloadPieceMove{
+		RoomID: "room",
+		Fen:    "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1",
+		Move:   json.RawMessage(`{"from":"e2","to":"e4","promotion":null}`),
+		UCI:    "e2e4",
+		SAN:    "e4",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	move.TraceID = "trace"
+	move.TraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	move.TraceState = "vendor=value"
+
+	limited, err := NewEvent(EventRateLimited, PayloadRateLimited{EventType: EventPieceMove, RetryAfterMs: 1500})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []Event{
+		move,
+		limited,
+		NewEventStruct(EventSubscribeLobby, json.RawMessage(`null`), ""),
+		NewEventStruct("custom", json.RawMessage(`{"list":[1,-2,3.5,"x",true],"nested":{"empty":{}}}`), "t"),
+	}
+
+	codecs := map[string]struct {
+		codec       Codec
+		messageType int
+	}{
+		"json":     {jsonCodec, websocket.TextMessage},
+		"msgpack":  {msgpackCodec, websocket.BinaryMessage},
+		"protobuf": {protobufCodec, websocket.BinaryMessage},
+	}
+
+	for name, tc := range codecs {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.codec.MessageType(); got != tc.messageType {
+				t.Errorf("MessageType() = %v, want %v", got, tc.messageType)
+			}
+
+			for _, evt := range events {
+				data, err := tc.codec.Encode(evt)
+
+				if err != nil {
+					t.Fatalf("encoding %v: %v", evt.Type, err)
+				}
+
+				got, err := tc.codec.Decode(data)
+
+				if err != nil {
+					t.Fatalf("decoding %v: %v", evt.Type, err)
+				}
+
+				if got.Type != evt.Type || got.TraceID != evt.TraceID || got.TraceParent != evt.TraceParent || got.TraceState != evt.TraceState {
+					t.Errorf("envelope of %v = %+v, want %+v", evt.Type, got, evt)
+				}
+
+				var gotPayload, wantPayload any
+
+				if err := json.Unmarshal(got.Payload, &gotPayload); err != nil {
+					t.Fatalf("decoded payload of %v: %v", evt.Type, err)
+				}
+
+				if err := json.Unmarshal(evt.Payload, &wantPayload); err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(gotPayload, wantPayload) {
+					t.Errorf("payload of %v = %s, want %s", evt.Type, got.Payload, evt.Payload)
+				}
+			}
+		})
+	}
+}
+
+func TestCodecDecodeInvalid(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": jsonCodec, "msgpack": msgpackCodec, "protobuf": protobufCodec} {
+		if _, err := codec.Decode([]byte{0xff, 0xff, 0xff}); err == nil {
+			t.Errorf("%v: decoding garbage succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/exp/slices"
+)
+
+// how often correspondence deadlines are checked
+var correspondenceInterval = time.Minute
+
+func isCorrespondence(room map[string]string) bool {
+	return room[util.RoomModeKey] == util.ModeCorrespondence
+}
+
+// Queues the bookkeeping for a correspondence game that just started: keeping the room
+// until the game ends, listing it for both players and starting the first move's clock
+func startCorrespondenceGame(ctx context.Context, pipe redis.Cmdable, room map[string]string) {
+	roomID := room[util.RoomIDKey]
+
+	pipe.Persist(ctx, util.GetRoomKey(roomID))
+
+	pipe.SAdd(ctx, util.GetUserCorrespondenceKey(room[util.RoomPlayer1Key]), roomID)
+	pipe.SAdd(ctx, util.GetUserCorrespondenceKey(room[util.RoomPlayer2Key]), roomID)
+
+	setMoveDeadline(ctx, pipe, room)
+}
+
+// Queues resetting the clock of the player to move to the room's days per move
+func setMoveDeadline(ctx context.Context, pipe redis.Cmdable, room map[string]string) {
+	days, err := strconv.Atoi(room[util.RoomDaysPerMoveKey])
+
+	if err != nil || days < 1 {
+		days = 1
+	}
+
+	deadline := time.Now().Add(time.Duration(days) * 24 * time.Hour).Unix()
+	roomID := room[util.RoomIDKey]
+
+	pipe.HSet(ctx, util.GetRoomKey(roomID), util.RoomMoveDeadlineKey, deadline)
+	pipe.ZAdd(ctx, util.CorrespondenceDeadlinesKey, redis.Z{Score: float64(deadline), Member: roomID})
+
+	room[util.RoomMoveDeadlineKey] = strconv.FormatInt(deadline, 10)
+}
+
+// Adjudicates correspondence games whose player to move ran out of time, until ctx is cancelled.
+// Every instance runs this, the room transaction makes sure each game is only adjudicated once.
+func (m *Manager) RunCorrespondenceScheduler(ctx context.Context) {
+	ticker := time.NewTicker(correspondenceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.adjudicateTimeouts(ctx); err != nil {
+				log.Printf("error adjudicating correspondence timeouts: %v", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) adjudicateTimeouts(ctx context.Context) error {
+	roomIDs, err := m.rdb.ZRangeByScore(ctx, util.CorrespondenceDeadlinesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+
+	if err != nil {
+		return err
+	}
+
+	for _, roomID := range roomIDs {
+		if err := m.adjudicateTimeout(ctx, roomID); err != nil {
+			log.Printf("error adjudicating timeout in room %v: %v", roomID, err)
+		}
+	}
+
+	return nil
+}
+
+var errNotTimedOut = errors.New("not timed out")
+
+// Ends the game in the room with the player to move losing on time
+func (m *Manager) adjudicateTimeout(ctx context.Context, roomID string) error {
+	room, err := m.updateRoom(ctx, roomID, func(room map[string]string, pipe redis.Pipeliner) error {
+		if len(room) == 0 || room[util.RoomResultKey] != "" {
+			return errNotTimedOut
+		}
+
+		deadline, err := strconv.ParseInt(room[util.RoomMoveDeadlineKey], 10, 64)
+
+		// a move was made since the deadlines were read
+		if err == nil && deadline > time.Now().Unix() {
+			return errNotTimedOut
+		}
+
+		result := util.ResultBlackWins
+
+		if sideToMove(room[util.RoomGameStateKey]) == util.ColorBlack {
+			result = util.ResultWhiteWins
+		}
+
+		setResult(ctx, pipe, room, result, util.TerminationTimeout)
+
+		return nil
+	})
+
+	if errors.Is(err, errNotTimedOut) {
+		// gone or already over, stop tracking it
+		if len(room) == 0 || room[util.RoomResultKey] != "" {
+			return m.rdb.ZRem(ctx, util.CorrespondenceDeadlinesKey, roomID).Err()
+		}
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return m.emitGameOver(roomID, room)
+}
+
+// Returns the user's correspondence games in which it's their turn to move, soonest deadline first
+func (m *Manager) MyTurnGames(ctx context.Context, userID string) ([]PayloadRoomState, error) {
+	userKey := util.GetUserCorrespondenceKey(userID)
+
+	roomIDs, err := m.rdb.SMembers(ctx, userKey).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	games := []PayloadRoomState{}
+
+	for _, roomID := range roomIDs {
+		room, err := m.rdb.HGetAll(ctx, util.GetRoomKey(roomID)).Result()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// the room expired or the game is over, stop listing it
+		if len(room) == 0 || room[util.RoomResultKey] != "" {
+			m.rdb.SRem(ctx, userKey, roomID)
+			continue
+		}
+
+		color, ok := playerColor(room, userID)
+
+		if ok && room[util.RoomGameStartedKey] == util.GameStartedTrue.String() && sideToMove(room[util.RoomGameStateKey]) == color {
+			games = append(games, NewRoomState(room))
+		}
+	}
+
+	sortByDeadline(games)
+
+	return games, nil
+}
+
+func sortByDeadline(games []PayloadRoomState) {
+	slices.SortFunc(games, func(a, b PayloadRoomState) int {
+		return int(a.MoveDeadline - b.MoveDeadline)
+	})
+}
@@ -4,31 +4,103 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/judgegodwins/chess-server/chess"
+	"github.com/judgegodwins/chess-server/util"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type Event struct {
-	Type    string          `json:"type"`
+	Type string `json:"type"`
+	// Client generated id, unique per user (e.g. a UUID). Events carrying one are acked
+	// or answered with an error event, and retries with the same id are only handled once.
 	TraceID string          `json:"trace_id"`
 	Payload json.RawMessage `json:"payload"`
+	// W3C trace context headers, letting clients continue a trace across the socket
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
 }
 
 type EventHandler func(ctx context.Context, evt Event, c *Client) error
 
+// Inbound events, sent by clients
+const (
+	EventSendMessage = "send_message"
+	EventJoinRoom    = "join_room"
+	EventAcceptJoin  = "accept_join_request"
+	EventRejectJoin  = "reject_join_request"
+	EventCancelJoin  = "cancel_join_request"
+	EventPieceMove   = "piece_move"
+	EventCloseRoom   = "close_room"
+	EventAuth        = "auth"
+	EventReauth      = "reauth"
+	EventResign      = "resign"
+	EventDraw        = "draw"
+
+	EventSubscribeLobby   = "subscribe_lobby"
+	EventUnsubscribeLobby = "unsubscribe_lobby"
+	EventCreateChallenge  = "create_challenge"
+	EventAcceptChallenge  = "accept_challenge"
+	EventCancelChallenge  = "cancel_challenge"
+	EventChallengeUser    = "challenge_user"
+	EventDeclineChallenge = "decline_challenge"
+	EventOfferRematch     = "offer_rematch"
+	EventAcceptRematch    = "accept_rematch"
+)
+
+// Outbound events, sent by the server
 const (
-	EventSendMessage    = "send_message"
-	EventJoinRoom       = "join_room"
-	EventAcceptJoin     = "accept_join_request"
-	EventPieceMove      = "piece_move"
-	EventError          = "error"
-	EventUserDisconnect = "user_disconnect"
-	EventUserConnect    = "user_connect"
-	EventRoomNotFound   = "room_not_found"
-	EventRequestJoin    = "request_join"
-	EventStartGame      = "start_game"
-	EventCloseRoom      = "close_room"
-	EventClosingRoom = "closing_room"
+	EventError              = "error"
+	EventUserDisconnect     = "user_disconnect"
+	EventUserConnect        = "user_connect"
+	EventRoomNotFound       = "room_not_found"
+	EventRoomFull           = "room_full"
+	EventJoinedRoom         = "joined_room"
+	EventConnElsewhere      = "conn_elsewhere"
+	EventRequestJoin        = "request_join"
+	EventJoinRequestRemoved = "join_request_removed"
+	EventStartGame          = "start_game"
+	EventClosingRoom        = "closing_room"
+	EventRateLimited        = "rate_limited"
+	EventAuthenticated      = "authenticated"
+	EventTokenExpiring      = "token_expiring"
+	EventAck                = "ack"
+	EventRoomState          = "room_state"
+	EventGameOver           = "game_over"
+	EventDrawOffered        = "draw_offered"
+	EventDrawDeclined       = "draw_declined"
+	EventAnalysisProgress   = "analysis_progress"
+	EventOpeningDetected    = "opening_detected"
+	EventLobby              = "lobby"
+	EventChallengeCreated   = "challenge_created"
+	EventChallengeRemoved   = "challenge_removed"
+	EventChallengeReceived  = "challenge_received"
+	EventRematchOffered     = "rematch_offered"
+	EventRematch            = "rematch"
 )
 
+type PayloadAuth struct {
+	Token string `json:"token"`
+}
+
+type PayloadAuthenticated struct {
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type PayloadTokenExpiring struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type PayloadAck struct {
+	EventType string `json:"event_type"`
+	// true if the event was a retry of one that had already been handled
+	Duplicate bool `json:"duplicate"`
+}
+
 type PayloadError struct {
 	Message string `json:"message"`
 }
@@ -42,22 +114,243 @@ type PayloadRoom struct {
 	RoomID string `json:"room_id"`
 }
 
+type PayloadJoinRoom struct {
+	// the room to join, by its id or its invite code
+	RoomID     string `json:"room_id,omitempty"`
+	InviteCode string `json:"invite_code,omitempty"`
+	// required to ask to join rooms with a password
+	Password string `json:"password,omitempty"`
+}
+
 type PayloadAcceptJoinRequest struct {
-	RoomID   string `json:"room_id"`
-	ClientID string `json:"client_id"`
+	RoomID string `json:"room_id"`
+	// Deprecated: ignored, the requester's connection is taken from their join request
+	ClientID string `json:"client_id,omitempty"`
 	PlayerID string `json:"player_id"`
 }
 
 type PayloadPieceMove struct {
-	RoomID string          `json:"room_id"`
-	Fen    string          `json:"fen"`
-	Move   json.RawMessage `json:"move"`
+	RoomID string `json:"room_id"`
+	// position after the move. Optional when sending a move, the server checks it if present.
+	Fen string `json:"fen,omitempty"`
+	// a UCI string like "e2e4" or a chess.js style {from, to, promotion} object, always the UCI string on broadcast
+	Move json.RawMessage `json:"move"`
+	// the move in UCI and standard algebraic notation, set by the server on broadcast
+	UCI string `json:"uci,omitempty"`
+	SAN string `json:"san,omitempty"`
+	// number of moves played including this one, set by the server on broadcast.
+	// Spectators can drop moves already in the room_state they started from.
+	Ply int `json:"ply,omitempty"`
+}
+
+// Room data sent with joined_room and start_game, mirroring the room hash in redis
+type PayloadRoomState struct {
+	ID              string `json:"id"`
+	Player1         string `json:"player1"`
+	Player1Username string `json:"player1_username"`
+	Player2         string `json:"player2"`
+	Player2Username string `json:"player2_username"`
+	GameState       string `json:"game_state"`
+	Active          string `json:"active" enum:"no,yes"`
+	Player1Color    string `json:"player1_color" enum:"w,b"`
+	Result          string `json:"result,omitempty"`
+	Termination     string `json:"termination,omitempty"`
+	DrawOffer       string `json:"draw_offer,omitempty"`
+	Mode            string `json:"mode" enum:"live,correspondence"`
+	DaysPerMove     int    `json:"days_per_move,omitempty"`
+	// unix time the player to move runs out of time in correspondence games
+	MoveDeadline int64  `json:"move_deadline,omitempty"`
+	Variant      string `json:"variant" enum:"standard,chess960,king_of_the_hill,three_check,from_position"`
+	InitialFEN   string `json:"initial_fen"`
+	// moves played so far in UCI notation, separated by spaces
+	Moves string `json:"moves"`
+	// the opening the game reached most recently, if it reached one
+	ECO     string `json:"eco,omitempty"`
+	Opening string `json:"opening,omitempty"`
+	// after the game, the player offering a rematch and the room of the rematch once accepted
+	RematchOffer string `json:"rematch_offer,omitempty"`
+	Rematch      string `json:"rematch,omitempty"`
+}
+
+// Builds the room state payload from a room hash
+func NewRoomState(room map[string]string) PayloadRoomState {
+	state := PayloadRoomState{
+		ID:              room[util.RoomIDKey],
+		Player1:         room[util.RoomPlayer1Key],
+		Player1Username: room[util.RoomPlayer1UsernameKey],
+		Player2:         room[util.RoomPlayer2Key],
+		Player2Username: room[util.RoomPlayer2UsernameKey],
+		GameState:       room[util.RoomGameStateKey],
+		Active:          room[util.RoomGameStartedKey],
+		Player1Color:    room[util.RoomPlayer1ColorKey],
+		Result:          room[util.RoomResultKey],
+		Termination:     room[util.RoomTerminationKey],
+		DrawOffer:       room[util.RoomDrawOfferKey],
+		Variant:         room[util.RoomVariantKey],
+		InitialFEN:      room[util.RoomInitialFENKey],
+		Moves:           room[util.RoomMovesKey],
+		ECO:             room[util.RoomECOKey],
+		Opening:         room[util.RoomOpeningKey],
+		RematchOffer:    room[util.RoomRematchOfferKey],
+		Rematch:         room[util.RoomRematchKey],
+	}
+
+	state.Mode = room[util.RoomModeKey]
+
+	if state.Mode == "" {
+		state.Mode = util.ModeLive
+	}
+
+	state.DaysPerMove, _ = strconv.Atoi(room[util.RoomDaysPerMoveKey])
+	state.MoveDeadline, _ = strconv.ParseInt(room[util.RoomMoveDeadlineKey], 10, 64)
+
+	// rooms created before variants were stored play standard chess
+	if state.Variant == "" {
+		state.Variant = string(chess.Standard)
+		state.InitialFEN = util.DefaultFEN
+	}
+
+	// rooms created before colours were stored have player1 as white
+	if state.Player1Color == "" {
+		state.Player1Color = util.ColorWhite
+	}
+
+	return state
+}
+
+type PayloadJoinRequest struct {
+	ID       string `json:"id"`
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	RoomID   string `json:"room_id"`
+	// unix time the request expires if the creator doesn't answer it
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+type PayloadRejectJoinRequest struct {
+	RoomID string `json:"room_id"`
+	// the requesting user
+	UserID string `json:"user_id"`
+}
+
+type PayloadJoinRequestRemoved struct {
+	RoomID string `json:"room_id"`
+	// the requesting user
+	UserID string `json:"user_id"`
+	Reason string `json:"reason" enum:"rejected,cancelled,expired,room_full,room_closed"`
+}
+
+type PayloadDrawAction struct {
+	RoomID string `json:"room_id"`
+	Action string `json:"action" enum:"offer,accept,decline"`
+}
+
+type PayloadDraw struct {
+	RoomID string `json:"room_id"`
+	// the user who offered or declined the draw
+	UserID string `json:"user_id"`
+}
+
+type PayloadGameOver struct {
+	RoomID      string `json:"room_id"`
+	Result      string `json:"result" enum:"1-0,0-1,1/2-1/2"`
+	Termination string `json:"termination"`
+}
+
+type PayloadRematchOffer struct {
+	RoomID string `json:"room_id"`
+	// the player who offered the rematch
+	UserID string `json:"user_id"`
+}
+
+type PayloadRematch struct {
+	RoomID string `json:"room_id"`
+	// room of the new game, which the players' clients have been moved into
+	RematchRoomID string `json:"rematch_room_id"`
+}
+
+type PayloadAnalysisProgress struct {
+	GameID string `json:"game_id"`
+	Status string `json:"status" enum:"pending,running,done,failed"`
+	// moves analysed so far, out of the game's total
+	Analyzed int `json:"analyzed"`
+	Total    int `json:"total"`
+}
+
+type PayloadOpening struct {
+	RoomID string `json:"room_id"`
+	ECO    string `json:"eco"`
+	Name   string `json:"name"`
+}
+
+type PayloadCreateChallenge struct {
+	Mode string `json:"mode,omitempty" enum:"live,correspondence"`
+	// required for correspondence games, from 1 to 30
+	DaysPerMove int    `json:"days_per_move,omitempty"`
+	Variant     string `json:"variant,omitempty" enum:"standard,chess960,king_of_the_hill,three_check"`
+	// colour the creator wants to play, random by default
+	Color string `json:"color,omitempty" enum:"w,b,random"`
+}
+
+type PayloadChallengeUser struct {
+	// id of the challenged user
+	UserID string `json:"user_id"`
+	Mode   string `json:"mode,omitempty" enum:"live,correspondence"`
+	// required for correspondence games, from 1 to 30
+	DaysPerMove int    `json:"days_per_move,omitempty"`
+	Variant     string `json:"variant,omitempty" enum:"standard,chess960,king_of_the_hill,three_check"`
+	// colour the challenger wants to play, random by default
+	Color string `json:"color,omitempty" enum:"w,b,random"`
+}
+
+type PayloadChallenge struct {
+	ChallengeID string `json:"challenge_id"`
+}
+
+type PayloadChallengeRemoved struct {
+	ChallengeID string `json:"challenge_id"`
+	Reason      string `json:"reason" enum:"accepted,cancelled,disconnected,declined"`
+}
+
+type PayloadLobby struct {
+	// open challenges, oldest first
+	Challenges []Challenge `json:"challenges"`
+}
+
+type PayloadRateLimited struct {
+	EventType    string `json:"event_type"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
 }
 
 type PayloadUser struct {
 	UserID string `json:"user_id"`
 }
 
+// Returns a context carrying the remote span context sent with the event, if any
+func (e Event) extractTraceContext(ctx context.Context) context.Context {
+	carrier := propagation.MapCarrier{}
+
+	if e.TraceParent != "" {
+		carrier["traceparent"] = e.TraceParent
+	}
+
+	if e.TraceState != "" {
+		carrier["tracestate"] = e.TraceState
+	}
+
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// Sets the event's trace context headers from the span in ctx
+func (e *Event) injectTraceContext(ctx context.Context) {
+	carrier := propagation.MapCarrier{}
+
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	e.TraceParent = carrier["traceparent"]
+	e.TraceState = carrier["tracestate"]
+}
+
 func NewEvent(evtType string, payload any) (Event, error) {
 	b, err := json.Marshal(payload)
 
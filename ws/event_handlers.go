@@ -6,14 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
-
-	// "time"
+	"time"
 
 	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
 )
 
 func JoinGameRoom(ctx context.Context, e Event, c *Client) error {
-	var payload PayloadRoom
+	var payload PayloadJoinRoom
 
 	// unmarshal payload bytes
 	err := json.Unmarshal(e.Payload, &payload)
@@ -22,6 +22,19 @@ func JoinGameRoom(ctx context.Context, e Event, c *Client) error {
 		return err
 	}
 
+	// invite codes stand in for the room's id
+	if payload.RoomID == "" && payload.InviteCode != "" {
+		payload.RoomID, err = RoomIDByInviteCode(ctx, c.manager.rdb, payload.InviteCode)
+
+		if errors.Is(err, ErrRoomNotFound) {
+			return c.PushEventToEgress(EventRoomNotFound, nil)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
 	roomKey := util.GetRoomKey(payload.RoomID)
 
 	// get room data from redis
@@ -45,27 +58,21 @@ func JoinGameRoom(ctx context.Context, e Event, c *Client) error {
 		return fmt.Errorf("userID not found in Data map of client with id %v", c.ID)
 	}
 
-	fmt.Println("roomplayer1 and userID", room[util.RoomPlayer1Key], userID)
-
 	// if client is already one of the players in the room
 	if room[util.RoomPlayer1Key] == userID || room[util.RoomPlayer2Key] == userID {
 		// if the connecting user has a tab/device already connected to this room (maybe on some other device)
 		// disconnect them from the room on the other device
-		if len(c.manager.Rooms[payload.RoomID]) > 0 {
-			for _, client := range c.manager.Rooms[payload.RoomID] {
-				if client.Data["userID"] == userID && client.ID != c.ID {
-					client.PushEventToEgress("conn_elsewhere", payload.RoomID)
-				}
+		for _, client := range c.manager.roomClients(payload.RoomID) {
+			if client.Data["userID"] == userID && client.ID != c.ID {
+				client.PushEventToEgress(EventConnElsewhere, payload.RoomID)
 			}
 		}
-		fmt.Println("room Created", payload.RoomID)
+
 		// make client join room
 		c.Join(payload.RoomID)
 
-		fmt.Println("manager rooms", c.manager.Rooms)
-
 		// create a joined_room event that'll tell the client that it has joined a room
-		err := c.PushEventToEgress("joined_room", room)
+		err := c.PushEventToEgress(EventJoinedRoom, NewRoomState(room))
 		if err != nil {
 			return err
 		}
@@ -89,7 +96,8 @@ func JoinGameRoom(ctx context.Context, e Event, c *Client) error {
 		if room[util.RoomGameStartedKey] == util.GameStartedTrue.String() {
 			// if user is player1 and player2 is disconnected, tell joining user that the opponent is disconnected
 			if room[util.RoomPlayer1Key] == userID {
-				if len(c.manager.Rooms[room[util.RoomPlayer2Key]]) == 0 {
+				// bots are always there, without a connection of their own
+				if len(c.manager.roomClients(room[util.RoomPlayer2Key])) == 0 && !isBot(room[util.RoomPlayer2Key]) {
 					err := c.manager.EmitUserDisconnect(room[util.RoomPlayer2Key], payload.RoomID)
 
 					if err != nil {
@@ -98,7 +106,7 @@ func JoinGameRoom(ctx context.Context, e Event, c *Client) error {
 				}
 				// else if user is player2 and player1 is disconnected, tell joining user that the opponent is disconnected
 			} else if room[util.RoomPlayer2Key] == userID {
-				if len(c.manager.Rooms[room[util.RoomPlayer1Key]]) == 0 {
+				if len(c.manager.roomClients(room[util.RoomPlayer1Key])) == 0 {
 					err := c.manager.EmitUserDisconnect(room[util.RoomPlayer1Key], payload.RoomID)
 
 					if err != nil {
@@ -112,19 +120,53 @@ func JoinGameRoom(ctx context.Context, e Event, c *Client) error {
 		// exit func
 	}
 
+	// private rooms look like they don't exist to users without the invite code
+	if !CanSeeRoom(room, userID, payload.InviteCode) {
+		return c.PushEventToEgress(EventRoomNotFound, nil)
+	}
+
 	if room[util.RoomPlayer2Key] != "" {
-		err := c.PushEventToEgress("room_full", nil)
+		err := c.PushEventToEgress(EventRoomFull, nil)
 		if err != nil {
 			return err
 		}
 		return nil
 	}
 
-	evt, err := NewEvent("request_join", map[string]interface{}{
-		"id":        c.Data["userID"],
-		"client_id": c.ID,
-		"username":  c.Data["username"],
-	})
+	if err := checkRoomPassword(room, payload.Password); err != nil {
+		return err
+	}
+
+	username, _ := c.Data["username"].(string)
+
+	// first come, first seated
+	if AutoAccepts(room) {
+		room, err := c.manager.seatOpponent(ctx, payload.RoomID, seat{ID: userID, Username: username})
+
+		if errors.Is(err, ErrRoomFull) {
+			return c.PushEventToEgress(EventRoomFull, nil)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return c.manager.startGameFor(room, c)
+	}
+
+	request := PayloadJoinRequest{
+		ID:        userID,
+		ClientID:  c.ID,
+		Username:  username,
+		RoomID:    payload.RoomID,
+		ExpiresAt: time.Now().Add(util.JoinRequestTTL).Unix(),
+	}
+
+	if err := c.manager.addJoinRequest(ctx, request); err != nil {
+		return err
+	}
+
+	evt, err := NewEvent(EventRequestJoin, request)
 
 	if err != nil {
 		return err
@@ -143,81 +185,108 @@ func AcceptJoinRequest(ctx context.Context, e Event, c *Client) error {
 		return err
 	}
 
-	roomKey := util.GetRoomKey(payload.RoomID)
-	room, err := c.manager.rdb.HGetAll(ctx, roomKey).Result()
+	userID, err := c.userID()
 
 	if err != nil {
 		return err
 	}
 
-	player1ID := room[util.RoomPlayer1Key]
+	if err := c.manager.checkRoomCreator(ctx, payload.RoomID, userID); err != nil {
+		return err
+	}
+
+	// only users who asked to join and are still waiting can be seated
+	request, err := c.manager.removeJoinRequest(ctx, payload.RoomID, payload.PlayerID)
 
-	if len(room) == 0 || player1ID == "" {
-		return errors.New("room details not found")
+	if err != nil {
+		return err
 	}
 
-	client := c.manager.clients[payload.ClientID]
+	client := c.manager.getClient(request.ClientID)
 
 	if client == nil {
+		c.manager.restoreJoinRequest(ctx, request)
 		return errors.New("the second player is not online")
 	}
 
-	username, ok := client.Data["username"].(string)
+	room, err := c.manager.seatOpponent(ctx, payload.RoomID, seat{ID: request.ID, Username: request.Username})
 
-	if !ok {
-		log.Printf("username for client (%v) not set", client)
-		return errors.New("an error occurred while adding the opponent to the room")
-	}
+	if errors.Is(err, ErrRoomFull) || errors.Is(err, ErrRoomNotFound) {
+		// the request can't be granted anymore, tell the requester instead of putting it back
+		reason := JoinRequestRoomFull
 
-	client.Join(payload.RoomID)
+		if errors.Is(err, ErrRoomNotFound) {
+			reason = JoinRequestRoomClosed
+		}
+
+		if err := c.manager.emitJoinRequestRemoved(payload.RoomID, request.ID, reason); err != nil {
+			log.Printf("error removing join request of user %v to room %v: %v", request.ID, payload.RoomID, err)
+		}
 
-	// set player2 to user ID
-	if err := c.manager.rdb.HSet(ctx, roomKey, util.RoomPlayer2Key, payload.PlayerID).Err(); err != nil {
 		return err
 	}
 
-	if err := c.manager.rdb.HSet(ctx, roomKey, util.RoomPlayer2UsernameKey, username).Err(); err != nil {
+	if err != nil {
+		// let the creator try again
+		c.manager.restoreJoinRequest(ctx, request)
 		return err
 	}
 
-	// set active = "yes"
-	if err = c.manager.rdb.HSet(ctx, roomKey, util.RoomGameStartedKey, util.GameStartedTrue.String()).Err(); err != nil {
+	// emit start_game event to game room
+	if err := c.manager.startGameFor(room, client); err != nil {
 		return err
 	}
 
-	room[util.RoomPlayer2Key] = payload.PlayerID
-	room[util.RoomPlayer2UsernameKey] = username
-	room[util.RoomGameStartedKey] = util.GameStartedTrue.String()
+	// the other requesters can stop waiting
+	return c.manager.clearJoinRequests(ctx, payload.RoomID, JoinRequestRoomFull)
+}
+
+func PieceMoveHandler(ctx context.Context, e Event, c *Client) error {
+	userID, err := c.userID()
 
-	// create start_game event
-	evt, err := NewEvent(EventStartGame, room)
 	if err != nil {
 		return err
 	}
 
-	// emit start_game event to game room
-	c.manager.EmitToRoom(payload.RoomID, evt)
+	_, err = c.manager.SubmitMove(ctx, userID, e)
 
-	return nil
+	return err
 }
 
-func PieceMoveHandler(ctx context.Context, e Event, c *Client) error {
-	var payload PayloadPieceMove
+func ResignHandler(ctx context.Context, e Event, c *Client) error {
+	var payload PayloadRoom
 
 	if err := json.Unmarshal(e.Payload, &payload); err != nil {
 		return err
 	}
 
-	roomKey := util.GetRoomKey(payload.RoomID)
+	userID, err := c.userID()
+
+	if err != nil {
+		return err
+	}
 
-	c.manager.EmitToRoom(payload.RoomID, e)
+	_, err = c.manager.Resign(ctx, userID, payload.RoomID)
 
-	// update FEN state of game
-	if err := c.manager.rdb.HSet(ctx, roomKey, util.RoomGameStateKey, payload.Fen).Err(); err != nil {
+	return err
+}
+
+func DrawHandler(ctx context.Context, e Event, c *Client) error {
+	var payload PayloadDrawAction
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
 		return err
 	}
 
-	return nil
+	userID, err := c.userID()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = c.manager.Draw(ctx, userID, payload.RoomID, payload.Action)
+
+	return err
 }
 
 func CloseRoom(ctx context.Context, e Event, c *Client) error {
@@ -230,8 +299,48 @@ func CloseRoom(ctx context.Context, e Event, c *Client) error {
 		return err
 	}
 
+	userID, err := c.userID()
+
+	if err != nil {
+		return err
+	}
+
+	roomKey := util.GetRoomKey(payload.RoomID)
+
+	room, err := c.manager.rdb.HMGet(ctx, roomKey, util.RoomPlayer1Key, util.RoomInviteCodeKey).Result()
+
+	if err != nil {
+		return err
+	}
+
+	if room[0] == nil {
+		return ErrRoomNotFound
+	}
+
+	// only the room's creator can close it
+	if creator, _ := room[0].(string); creator != userID {
+		return ErrNotRoomCreator
+	}
+
+	inviteCode, _ := room[1].(string)
+
 	// delete room data on redis
-	if err = c.manager.rdb.Del(ctx, util.GetRoomKey(payload.RoomID)).Err(); err != nil {
+	_, err = c.manager.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, roomKey)
+		pipe.ZRem(ctx, util.PublicRoomsKey, payload.RoomID)
+
+		if inviteCode != "" {
+			pipe.Del(ctx, util.GetInviteKey(inviteCode))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := c.manager.clearJoinRequests(ctx, payload.RoomID, JoinRequestRoomClosed); err != nil {
 		return err
 	}
 
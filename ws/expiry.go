@@ -0,0 +1,100 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/judgegodwins/chess-server/tokens"
+)
+
+// how long before the token expires the client is sent a token_expiring event
+var tokenExpiryWarning = 2 * time.Minute
+
+var errTokenExpired = errors.New("token expired")
+
+// Watches the expiry of the token the client authenticated with. The client is warned
+// with a token_expiring event shortly before expiry, and the connection is closed once
+// the token lapses unless a reauth event extends it.
+func (c *Client) watchTokenExpiry(ctx context.Context, expiresAt time.Time) {
+	for {
+		// tokens without an exp claim never expire, but a later reauth may bring one that does
+		if expiresAt.IsZero() {
+			select {
+			case <-ctx.Done():
+				return
+			case expiresAt = <-c.reauth:
+			}
+
+			continue
+		}
+
+		warn := time.NewTimer(time.Until(expiresAt.Add(-tokenExpiryWarning)))
+		expire := time.NewTimer(time.Until(expiresAt))
+
+		select {
+		case <-ctx.Done():
+			warn.Stop()
+			expire.Stop()
+			return
+		case <-warn.C:
+			if err := c.PushEventToEgress(EventTokenExpiring, PayloadTokenExpiring{ExpiresAt: expiresAt}); err != nil {
+				expire.Stop()
+				c.handleError(err)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				expire.Stop()
+				return
+			case <-expire.C:
+				c.handleError(errTokenExpired)
+				return
+			case expiresAt = <-c.reauth:
+				expire.Stop()
+			}
+		case expiresAt = <-c.reauth:
+			warn.Stop()
+			expire.Stop()
+		}
+	}
+}
+
+// Replaces the token of a live session with a fresh one for the same user
+func ReauthHandler(ctx context.Context, e Event, c *Client) error {
+	var payload PayloadAuth
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return err
+	}
+
+	authPayload, err := tokens.ParseJWTToken(payload.Token, []byte(c.manager.config.JWTSecret))
+
+	if err != nil {
+		return err
+	}
+
+	if authPayload.ID != c.Data["userID"] {
+		return fmt.Errorf("token belongs to a different user")
+	}
+
+	// the watcher only needs the latest expiry. Events are handled one at a time, so after
+	// dropping an expiry it hasn't picked up yet there is room in the buffer.
+	select {
+	case <-c.reauth:
+	default:
+	}
+
+	select {
+	case c.reauth <- authPayload.ExpiresAt:
+	default:
+	}
+
+	return c.PushEventToEgress(EventAuthenticated, PayloadAuthenticated{
+		UserID:    authPayload.ID,
+		ExpiresAt: authPayload.ExpiresAt,
+	})
+}
@@ -0,0 +1,500 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/judgegodwins/chess-server/chess"
+	"github.com/judgegodwins/chess-server/eco"
+	"github.com/judgegodwins/chess-server/fen"
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// Errors returned by game actions, shared by the websocket handlers and the REST api
+var (
+	ErrRoomNotFound     = errors.New("room not found")
+	ErrNotAPlayer       = errors.New("you are not a player in this room")
+	ErrGameNotStarted   = errors.New("the game hasn't started")
+	ErrGameOver         = errors.New("the game is over")
+	ErrNotYourTurn      = errors.New("it is not your turn")
+	ErrInvalidMove      = errors.New("invalid move")
+	ErrNoDrawOffer      = errors.New("there is no draw offer to respond to")
+	ErrInvalidDrawReply = errors.New("invalid draw action")
+)
+
+// Draw actions
+const (
+	DrawOffer   = "offer"
+	DrawAccept  = "accept"
+	DrawDecline = "decline"
+)
+
+// Returns the colour userID plays in the room. Player1 is white unless the room says otherwise.
+func playerColor(room map[string]string, userID string) (string, bool) {
+	player1Color := room[util.RoomPlayer1ColorKey]
+
+	if player1Color == "" {
+		player1Color = util.ColorWhite
+	}
+
+	switch userID {
+	case room[util.RoomPlayer1Key]:
+		return player1Color, true
+	case room[util.RoomPlayer2Key]:
+		return oppositeColor(player1Color), true
+	}
+
+	return "", false
+}
+
+func oppositeColor(color string) string {
+	if color == util.ColorWhite {
+		return util.ColorBlack
+	}
+
+	return util.ColorWhite
+}
+
+// Returns the id of the other player in the room
+func opponentOf(room map[string]string, userID string) string {
+	if room[util.RoomPlayer1Key] == userID {
+		return room[util.RoomPlayer2Key]
+	}
+
+	return room[util.RoomPlayer1Key]
+}
+
+// Returns the colour to move in a FEN string, "" if it is malformed
+func sideToMove(s string) string {
+	b, err := fen.Parse(s)
+
+	if err != nil {
+		return ""
+	}
+
+	return string(b.Turn)
+}
+
+// Checks that the game in the room is in progress and userID is playing it. Returns userID's colour.
+func checkPlayer(room map[string]string, userID string) (string, error) {
+	if len(room) == 0 {
+		return "", ErrRoomNotFound
+	}
+
+	color, ok := playerColor(room, userID)
+
+	if !ok {
+		return "", ErrNotAPlayer
+	}
+
+	if room[util.RoomResultKey] != "" {
+		return "", ErrGameOver
+	}
+
+	if room[util.RoomGameStartedKey] != util.GameStartedTrue.String() {
+		return "", ErrGameNotStarted
+	}
+
+	return color, nil
+}
+
+// Runs fn with the room's data in a redis transaction watching the room, retrying
+// if the room changed in between so concurrent actions can't overwrite each other
+func (m *Manager) updateRoom(ctx context.Context, roomID string, fn func(room map[string]string, pipe redis.Pipeliner) error) (map[string]string, error) {
+	roomKey := util.GetRoomKey(roomID)
+
+	var room map[string]string
+
+	txf := func(tx *redis.Tx) error {
+		var err error
+
+		room, err = tx.HGetAll(ctx, roomKey).Result()
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return fn(room, pipe)
+		})
+
+		return err
+	}
+
+	for i := 0; i < 5; i++ {
+		err := m.rdb.Watch(ctx, txf, roomKey)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+
+		return room, err
+	}
+
+	return nil, fmt.Errorf("room %v: too many concurrent updates", roomID)
+}
+
+// Replays the game in the room from its initial position. Rooms without a variant play standard chess.
+func GameFromRoom(room map[string]string) (*chess.Game, error) {
+	variant := chess.Standard
+
+	if v := room[util.RoomVariantKey]; v != "" {
+		var err error
+
+		if variant, err = chess.ParseVariant(v); err != nil {
+			return nil, err
+		}
+	}
+
+	initialFEN := room[util.RoomInitialFENKey]
+
+	if initialFEN == "" {
+		initialFEN = util.DefaultFEN
+	}
+
+	game, err := chess.NewGame(variant, initialFEN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := game.PlayUCI(strings.Fields(room[util.RoomMovesKey])...); err != nil {
+		return nil, fmt.Errorf("replaying room %v: %w", room[util.RoomIDKey], err)
+	}
+
+	return game, nil
+}
+
+// a move as sent by chess.js
+type clientMove struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Promotion string `json:"promotion"`
+}
+
+// Parses a move sent by a client, either a UCI string or a chess.js style {from, to, promotion} object
+func parseClientMove(pos *chess.Position, raw json.RawMessage) (chess.Move, error) {
+	var uci string
+
+	if err := json.Unmarshal(raw, &uci); err != nil {
+		var m clientMove
+
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return chess.NoMove, fmt.Errorf("%w: move must be a uci string or a {from, to, promotion} object", ErrInvalidMove)
+		}
+
+		uci = m.From + m.To + m.Promotion
+	}
+
+	move, err := pos.ParseUCI(strings.ToLower(uci))
+
+	if err != nil {
+		return chess.NoMove, fmt.Errorf("%w: %v", ErrInvalidMove, err)
+	}
+
+	return move, nil
+}
+
+// Validates a piece_move event from userID against the room's variant, stores the new position and
+// broadcasts the move to the room. Used for moves sent over websockets and the REST api alike.
+func (m *Manager) SubmitMove(ctx context.Context, userID string, e Event) (map[string]string, error) {
+	var payload PayloadPieceMove
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return nil, err
+	}
+
+	var clientBoard *fen.Board
+
+	if payload.Fen != "" {
+		var err error
+
+		if clientBoard, err = fen.Parse(payload.Fen); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidMove, err)
+		}
+	}
+
+	var game *chess.Game
+	var opening *eco.Opening
+
+	room, err := m.updateRoom(ctx, payload.RoomID, func(room map[string]string, pipe redis.Pipeliner) error {
+		color, err := checkPlayer(room, userID)
+
+		if err != nil {
+			return err
+		}
+
+		if game, err = GameFromRoom(room); err != nil {
+			return err
+		}
+
+		if game.Position.Turn.String() != color {
+			return ErrNotYourTurn
+		}
+
+		move, err := parseClientMove(game.Position, payload.Move)
+
+		if err != nil {
+			return err
+		}
+
+		if err := game.Play(move); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidMove, err)
+		}
+
+		// the client's position is optional, but has to agree with the server's if sent
+		if clientBoard != nil && clientBoard.PlacementString()+" "+string(clientBoard.Turn) != game.Position.BoardFEN() {
+			return fmt.Errorf("%w: fen doesn't match the position after the move", ErrInvalidMove)
+		}
+
+		roomKey := util.GetRoomKey(payload.RoomID)
+		moves := strings.Join(game.UCIs, " ")
+
+		// update FEN state of game
+		pipe.HSet(ctx, roomKey, util.RoomGameStateKey, game.Position.FEN(), util.RoomMovesKey, moves)
+		room[util.RoomGameStateKey] = game.Position.FEN()
+		room[util.RoomMovesKey] = moves
+
+		// the game keeps the last opening it reached once it leaves the table.
+		// ECO codes classify standard openings only, other variants can reach the same positions.
+		if o, ok := eco.Lookup(game.Position); ok && game.Variant == chess.Standard && (o.ECO != room[util.RoomECOKey] || o.Name != room[util.RoomOpeningKey]) {
+			opening = &o
+			pipe.HSet(ctx, roomKey, util.RoomECOKey, o.ECO, util.RoomOpeningKey, o.Name)
+			room[util.RoomECOKey] = o.ECO
+			room[util.RoomOpeningKey] = o.Name
+		}
+
+		// moving declines the opponent's draw offer
+		if offer := room[util.RoomDrawOfferKey]; offer != "" && offer != userID {
+			pipe.HDel(ctx, roomKey, util.RoomDrawOfferKey)
+			delete(room, util.RoomDrawOfferKey)
+		}
+
+		if outcome := game.Outcome(); outcome != nil {
+			setResult(ctx, pipe, room, outcome.Result, outcome.Termination)
+		} else if isCorrespondence(room) {
+			// the opponent's clock starts now
+			setMoveDeadline(ctx, pipe, room)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// broadcast the server's position, with the move in both notations
+	payload.Fen = room[util.RoomGameStateKey]
+	payload.UCI = game.UCIs[len(game.UCIs)-1]
+	// not the client's move as sent, which can carry anything alongside the move
+	if payload.Move, err = json.Marshal(payload.UCI); err != nil {
+		return nil, err
+	}
+
+	payload.SAN = game.SANs[len(game.SANs)-1]
+	payload.Ply = len(game.UCIs)
+
+	evt, err := NewEvent(EventPieceMove, payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	evt.TraceID = e.TraceID
+
+	// let the opponent's client continue the trace of this move
+	evt.injectTraceContext(ctx)
+
+	m.EmitToRoom(payload.RoomID, evt)
+
+	if opening != nil {
+		evt, err := NewEvent(EventOpeningDetected, PayloadOpening{
+			RoomID: payload.RoomID,
+			ECO:    opening.ECO,
+			Name:   opening.Name,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		m.EmitToRoom(payload.RoomID, evt)
+	}
+
+	if room[util.RoomResultKey] != "" {
+		return room, m.emitGameOver(payload.RoomID, room)
+	}
+
+	if isBot(opponentOf(room, userID)) {
+		go m.PlayBotMove(payload.RoomID)
+	}
+
+	return room, nil
+}
+
+// Ends the game in the room with userID resigning
+func (m *Manager) Resign(ctx context.Context, userID, roomID string) (map[string]string, error) {
+	room, err := m.updateRoom(ctx, roomID, func(room map[string]string, pipe redis.Pipeliner) error {
+		color, err := checkPlayer(room, userID)
+
+		if err != nil {
+			return err
+		}
+
+		result := util.ResultWhiteWins
+
+		if color == util.ColorWhite {
+			result = util.ResultBlackWins
+		}
+
+		setResult(ctx, pipe, room, result, util.TerminationResignation)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return room, m.emitGameOver(roomID, room)
+}
+
+// Offers a draw, or accepts or declines the opponent's offer
+func (m *Manager) Draw(ctx context.Context, userID, roomID, action string) (map[string]string, error) {
+	var evtType string
+
+	room, err := m.updateRoom(ctx, roomID, func(room map[string]string, pipe redis.Pipeliner) error {
+		if _, err := checkPlayer(room, userID); err != nil {
+			return err
+		}
+
+		roomKey := util.GetRoomKey(roomID)
+		offer := room[util.RoomDrawOfferKey]
+
+		switch action {
+		case DrawOffer:
+			evtType = EventDrawOffered
+			pipe.HSet(ctx, roomKey, util.RoomDrawOfferKey, userID)
+			room[util.RoomDrawOfferKey] = userID
+		case DrawAccept:
+			if offer == "" || offer == userID {
+				return ErrNoDrawOffer
+			}
+
+			evtType = EventGameOver
+			setResult(ctx, pipe, room, util.ResultDraw, util.TerminationAgreement)
+		case DrawDecline:
+			if offer == "" || offer == userID {
+				return ErrNoDrawOffer
+			}
+
+			evtType = EventDrawDeclined
+			pipe.HDel(ctx, roomKey, util.RoomDrawOfferKey)
+			delete(room, util.RoomDrawOfferKey)
+		default:
+			return ErrInvalidDrawReply
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if evtType == EventGameOver {
+		return room, m.emitGameOver(roomID, room)
+	}
+
+	evt, err := NewEvent(evtType, PayloadDraw{
+		RoomID: roomID,
+		UserID: userID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.EmitToRoom(roomID, evt)
+
+	return room, nil
+}
+
+// Queues writing the game's result to the room hash
+func setResult(ctx context.Context, pipe redis.Pipeliner, room map[string]string, result, termination string) {
+	roomKey := util.GetRoomKey(room[util.RoomIDKey])
+
+	pipe.HSet(ctx, roomKey, util.RoomResultKey, result, util.RoomTerminationKey, termination)
+	pipe.HDel(ctx, roomKey, util.RoomDrawOfferKey)
+	// finished games are kept around as long as live rooms, even correspondence ones
+	pipe.Expire(ctx, roomKey, util.RoomTTL)
+
+	if isCorrespondence(room) {
+		pipe.ZRem(ctx, util.CorrespondenceDeadlinesKey, room[util.RoomIDKey])
+		pipe.SRem(ctx, util.GetUserCorrespondenceKey(room[util.RoomPlayer1Key]), room[util.RoomIDKey])
+		pipe.SRem(ctx, util.GetUserCorrespondenceKey(room[util.RoomPlayer2Key]), room[util.RoomIDKey])
+	}
+
+	room[util.RoomResultKey] = result
+	room[util.RoomTerminationKey] = termination
+	delete(room, util.RoomDrawOfferKey)
+
+	archiveGame(ctx, pipe, room)
+}
+
+// Room fields kept when a game is archived. Anything else about the room, like its password hash
+// and invite code, goes away with it.
+var archivedGameFields = []string{
+	util.RoomIDKey,
+	util.RoomPlayer1Key,
+	util.RoomPlayer2Key,
+	util.RoomPlayer1UsernameKey,
+	util.RoomPlayer2UsernameKey,
+	util.RoomPlayer1ColorKey,
+	util.RoomVariantKey,
+	util.RoomInitialFENKey,
+	util.RoomMovesKey,
+	util.RoomResultKey,
+	util.RoomTerminationKey,
+	util.RoomECOKey,
+	util.RoomOpeningKey,
+}
+
+// Queues copying a finished game out of its room, which expires or gets closed, so it can be analysed later
+func archiveGame(ctx context.Context, pipe redis.Pipeliner, room map[string]string) {
+	gameKey := util.GetGameKey(room[util.RoomIDKey])
+
+	game := map[string]any{util.GameEndedAtKey: time.Now().Unix()}
+
+	for _, field := range archivedGameFields {
+		if value, ok := room[field]; ok {
+			game[field] = value
+		}
+	}
+
+	pipe.HSet(ctx, gameKey, game)
+	pipe.Expire(ctx, gameKey, util.GameArchiveTTL)
+}
+
+// Tells the room the game ended
+func (m *Manager) emitGameOver(roomID string, room map[string]string) error {
+	evt, err := NewEvent(EventGameOver, PayloadGameOver{
+		RoomID:      roomID,
+		Result:      room[util.RoomResultKey],
+		Termination: room[util.RoomTerminationKey],
+	})
+
+	if err != nil {
+		return err
+	}
+
+	m.EmitToRoom(roomID, evt)
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// how many requests to join a room can wait for the creator at once
+const maxJoinRequests = 10
+
+// how often expired join requests are removed
+var joinRequestInterval = 10 * time.Second
+
+var (
+	ErrJoinRequestNotFound = errors.New("join request not found")
+	ErrTooManyJoinRequests = errors.New("the room has too many pending join requests, try again later")
+	ErrNotRoomCreator      = errors.New("only the room's creator can do that")
+)
+
+// Reasons a join request was removed
+const (
+	JoinRequestRejected   = "rejected"
+	JoinRequestCancelled  = "cancelled"
+	JoinRequestExpired    = "expired"
+	JoinRequestRoomFull   = "room_full"
+	JoinRequestRoomClosed = "room_closed"
+)
+
+func RejectJoinRequest(ctx context.Context, e Event, c *Client) error {
+	var payload PayloadRejectJoinRequest
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return err
+	}
+
+	userID, err := c.userID()
+
+	if err != nil {
+		return err
+	}
+
+	if err := c.manager.checkRoomCreator(ctx, payload.RoomID, userID); err != nil {
+		return err
+	}
+
+	if _, err := c.manager.removeJoinRequest(ctx, payload.RoomID, payload.UserID); err != nil {
+		return err
+	}
+
+	return c.manager.emitJoinRequestRemoved(payload.RoomID, payload.UserID, JoinRequestRejected)
+}
+
+func CancelJoinRequest(ctx context.Context, e Event, c *Client) error {
+	var payload PayloadRoom
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return err
+	}
+
+	userID, err := c.userID()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.manager.removeJoinRequest(ctx, payload.RoomID, userID); err != nil {
+		return err
+	}
+
+	return c.manager.emitJoinRequestRemoved(payload.RoomID, userID, JoinRequestCancelled)
+}
+
+// Returns ErrNotRoomCreator unless userID created the room
+func (m *Manager) checkRoomCreator(ctx context.Context, roomID, userID string) error {
+	creator, err := m.rdb.HGet(ctx, util.GetRoomKey(roomID), util.RoomPlayer1Key).Result()
+
+	if errors.Is(err, redis.Nil) {
+		return ErrRoomNotFound
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if creator != userID {
+		return ErrNotRoomCreator
+	}
+
+	return nil
+}
+
+// Member of util.JoinRequestDeadlinesKey for the request of userID to join the room
+func joinRequestMember(roomID, userID string) string {
+	return fmt.Sprintf("%v:%v", roomID, userID)
+}
+
+// Records a request to join the room, replacing the user's earlier request.
+// Returns ErrTooManyJoinRequests if the room already has maxJoinRequests other requests.
+func (m *Manager) addJoinRequest(ctx context.Context, request PayloadJoinRequest) error {
+	requestsKey := util.GetRoomJoinRequestsKey(request.RoomID)
+
+	data, err := json.Marshal(request)
+
+	if err != nil {
+		return err
+	}
+
+	txf := func(tx *redis.Tx) error {
+		requests, err := tx.HKeys(ctx, requestsKey).Result()
+
+		if err != nil {
+			return err
+		}
+
+		pending := len(requests)
+
+		for _, id := range requests {
+			if id == request.ID {
+				pending--
+			}
+		}
+
+		if pending >= maxJoinRequests {
+			return ErrTooManyJoinRequests
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, requestsKey, request.ID, data)
+			// outlives its requests so the scheduler can still tell the requesters they expired
+			pipe.Expire(ctx, requestsKey, 2*util.JoinRequestTTL)
+			pipe.ZAdd(ctx, util.JoinRequestDeadlinesKey, redis.Z{
+				Score:  float64(request.ExpiresAt),
+				Member: joinRequestMember(request.RoomID, request.ID),
+			})
+
+			return nil
+		})
+
+		return err
+	}
+
+	for i := 0; i < 5; i++ {
+		err := m.rdb.Watch(ctx, txf, requestsKey)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+
+		return err
+	}
+
+	return fmt.Errorf("room %v: too many concurrent join requests", request.RoomID)
+}
+
+// Removes the user's pending request to join the room. Only one caller gets the request,
+// the others get ErrJoinRequestNotFound.
+func (m *Manager) removeJoinRequest(ctx context.Context, roomID, userID string) (PayloadJoinRequest, error) {
+	var request PayloadJoinRequest
+
+	requestsKey := util.GetRoomJoinRequestsKey(roomID)
+
+	data, err := m.rdb.HGet(ctx, requestsKey, userID).Bytes()
+
+	if errors.Is(err, redis.Nil) {
+		return request, ErrJoinRequestNotFound
+	}
+
+	if err != nil {
+		return request, err
+	}
+
+	var deleted *redis.IntCmd
+
+	_, err = m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.HDel(ctx, requestsKey, userID)
+		pipe.ZRem(ctx, util.JoinRequestDeadlinesKey, joinRequestMember(roomID, userID))
+
+		return nil
+	})
+
+	if err != nil {
+		return request, err
+	}
+
+	if deleted.Val() == 0 {
+		return request, ErrJoinRequestNotFound
+	}
+
+	err = json.Unmarshal(data, &request)
+
+	return request, err
+}
+
+// Puts back a request taken with removeJoinRequest that couldn't be answered
+func (m *Manager) restoreJoinRequest(ctx context.Context, request PayloadJoinRequest) {
+	if err := m.addJoinRequest(ctx, request); err != nil {
+		log.Printf("error restoring join request of user %v to room %v: %v", request.ID, request.RoomID, err)
+	}
+}
+
+// Removes every pending request to join the room, telling the requesters why
+func (m *Manager) clearJoinRequests(ctx context.Context, roomID, reason string) error {
+	requestsKey := util.GetRoomJoinRequestsKey(roomID)
+
+	var requests *redis.StringSliceCmd
+
+	_, err := m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		requests = pipe.HKeys(ctx, requestsKey)
+		pipe.Del(ctx, requestsKey)
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if len(requests.Val()) == 0 {
+		return nil
+	}
+
+	members := make([]any, len(requests.Val()))
+
+	for i, userID := range requests.Val() {
+		members[i] = joinRequestMember(roomID, userID)
+	}
+
+	if err := m.rdb.ZRem(ctx, util.JoinRequestDeadlinesKey, members...).Err(); err != nil {
+		return err
+	}
+
+	for _, userID := range requests.Val() {
+		if err := m.emitJoinRequestRemoved(roomID, userID, reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tells the room's creator and the requesting user that the request was removed
+func (m *Manager) emitJoinRequestRemoved(roomID, userID, reason string) error {
+	evt, err := NewEvent(EventJoinRequestRemoved, PayloadJoinRequestRemoved{
+		RoomID: roomID,
+		UserID: userID,
+		Reason: reason,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	m.EmitToRoom(roomID, evt)
+	m.EmitToRoom(userID, evt)
+
+	return nil
+}
+
+// Removes join requests the room's creator didn't answer in time, until ctx is cancelled.
+// Every instance runs this, removeJoinRequest makes sure each request is only expired once.
+func (m *Manager) RunJoinRequestScheduler(ctx context.Context) {
+	ticker := time.NewTicker(joinRequestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.expireJoinRequests(ctx); err != nil {
+				log.Printf("error expiring join requests: %v", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) expireJoinRequests(ctx context.Context) error {
+	members, err := m.rdb.ZRangeByScore(ctx, util.JoinRequestDeadlinesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		roomID, userID, _ := strings.Cut(member, ":")
+
+		if err := m.expireJoinRequest(ctx, roomID, userID); err != nil {
+			log.Printf("error expiring join request of user %v to room %v: %v", userID, roomID, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) expireJoinRequest(ctx context.Context, roomID, userID string) error {
+	data, err := m.rdb.HGet(ctx, util.GetRoomJoinRequestsKey(roomID), userID).Bytes()
+
+	if err == nil {
+		var request PayloadJoinRequest
+
+		// the user asked again since the deadlines were read
+		if json.Unmarshal(data, &request) == nil && request.ExpiresAt > time.Now().Unix() {
+			return nil
+		}
+	}
+
+	_, err = m.removeJoinRequest(ctx, roomID, userID)
+
+	if errors.Is(err, ErrJoinRequestNotFound) {
+		// answered, or gone with its room, stop tracking it
+		return m.rdb.ZRem(ctx, util.JoinRequestDeadlinesKey, joinRequestMember(roomID, userID)).Err()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return m.emitJoinRequestRemoved(roomID, userID, JoinRequestExpired)
+}
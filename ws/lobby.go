@@ -0,0 +1,568 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// how often the challenges of connections to this instance are kept from expiring
+var challengeKeepAliveInterval = util.ChallengeTTL / 4
+
+var (
+	ErrChallengeNotFound = errors.New("challenge not found")
+	ErrOwnChallenge      = errors.New("you can't accept your own challenge")
+	ErrChallengeSelf     = errors.New("you can't challenge yourself")
+	ErrUserOffline       = errors.New("the user is not online")
+)
+
+// Reasons a challenge was removed
+const (
+	ChallengeAccepted     = "accepted"
+	ChallengeCancelled    = "cancelled"
+	ChallengeDisconnected = "disconnected"
+	ChallengeDeclined     = "declined"
+)
+
+// An open challenge. Challenges in the lobby can be accepted by anyone but their creator,
+// direct challenges only by the user they were sent to.
+type Challenge struct {
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	// the creator's rating, 0 if unrated
+	Rating      int    `json:"rating"`
+	Mode        string `json:"mode" enum:"live,correspondence"`
+	DaysPerMove int    `json:"days_per_move,omitempty"`
+	Variant     string `json:"variant" enum:"standard,chess960,king_of_the_hill,three_check"`
+	// colour the creator wants to play
+	Color     string `json:"color" enum:"w,b,random"`
+	CreatedAt int64  `json:"created_at"`
+	// connection the challenge was created from. The challenge is removed when it closes,
+	// and expires soon after if the instance holding the connection stops.
+	ClientID string `json:"client_id"`
+	// the challenged user of direct challenges, empty for challenges in the lobby
+	TargetID string `json:"target_id,omitempty"`
+}
+
+func SubscribeLobby(ctx context.Context, e Event, c *Client) error {
+	challenges, err := c.manager.lobbyChallenges(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	c.Join(util.LobbyRoomID)
+
+	return c.PushEventToEgress(EventLobby, PayloadLobby{Challenges: challenges})
+}
+
+func UnsubscribeLobby(ctx context.Context, e Event, c *Client) error {
+	c.Leave(util.LobbyRoomID)
+	return nil
+}
+
+func CreateChallenge(ctx context.Context, e Event, c *Client) error {
+	var payload PayloadCreateChallenge
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return err
+	}
+
+	challenge, err := newChallenge(c, payload)
+
+	if err != nil {
+		return err
+	}
+
+	if err := c.manager.openChallenge(ctx, challenge); err != nil {
+		return err
+	}
+
+	evt, err := NewEvent(EventChallengeCreated, challenge)
+
+	if err != nil {
+		return err
+	}
+
+	c.manager.EmitToRoom(util.LobbyRoomID, evt)
+
+	// the creator learns the challenge's id even if it isn't watching the lobby
+	if !c.manager.ClientInRoom(util.LobbyRoomID, c) {
+		c.PushToEgress(evt)
+	}
+
+	return nil
+}
+
+// Sends a challenge to a user who is online, delivered to every connection of theirs as challenge_received
+func ChallengeUser(ctx context.Context, e Event, c *Client) error {
+	var payload PayloadChallengeUser
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return err
+	}
+
+	challenge, err := newChallenge(c, PayloadCreateChallenge{
+		Mode:        payload.Mode,
+		DaysPerMove: payload.DaysPerMove,
+		Variant:     payload.Variant,
+		Color:       payload.Color,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	targetID := payload.UserID
+
+	if targetID == challenge.UserID {
+		return ErrChallengeSelf
+	}
+
+	online, err := c.manager.userOnline(ctx, targetID)
+
+	if err != nil {
+		return err
+	}
+
+	if !online {
+		return ErrUserOffline
+	}
+
+	challenge.TargetID = targetID
+
+	if err := c.manager.openChallenge(ctx, challenge); err != nil {
+		return err
+	}
+
+	evt, err := NewEvent(EventChallengeReceived, challenge)
+
+	if err != nil {
+		return err
+	}
+
+	c.manager.EmitToRoom(targetID, evt)
+
+	return c.PushEventToEgress(EventChallengeCreated, challenge)
+}
+
+// Builds a challenge from the creating client and the settings it asked for
+func newChallenge(c *Client, payload PayloadCreateChallenge) (*Challenge, error) {
+	userID, err := c.userID()
+
+	if err != nil {
+		return nil, err
+	}
+
+	settings := RoomSettings{
+		Mode:        payload.Mode,
+		DaysPerMove: payload.DaysPerMove,
+		Variant:     payload.Variant,
+	}
+
+	if err := settings.normalize(); err != nil {
+		return nil, err
+	}
+
+	color := payload.Color
+
+	if color == "" {
+		color = ColorRandom
+	}
+
+	if color != util.ColorWhite && color != util.ColorBlack && color != ColorRandom {
+		return nil, fmt.Errorf("%w: unknown colour %q", ErrInvalidSettings, color)
+	}
+
+	username, _ := c.Data["username"].(string)
+	rating, _ := c.Data["rating"].(int)
+
+	return &Challenge{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Username:    username,
+		Rating:      rating,
+		Mode:        settings.Mode,
+		DaysPerMove: settings.DaysPerMove,
+		Variant:     settings.Variant,
+		Color:       color,
+		CreatedAt:   time.Now().Unix(),
+		ClientID:    c.ID,
+	}, nil
+}
+
+func AcceptChallenge(ctx context.Context, e Event, c *Client) error {
+	var payload PayloadChallenge
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return err
+	}
+
+	userID, err := c.userID()
+
+	if err != nil {
+		return err
+	}
+
+	challenge, err := c.manager.getChallenge(ctx, payload.ChallengeID)
+
+	if err != nil {
+		return err
+	}
+
+	if challenge.UserID == userID {
+		return ErrOwnChallenge
+	}
+
+	// other users don't learn about direct challenges that weren't sent to them
+	if challenge.TargetID != "" && challenge.TargetID != userID {
+		return ErrChallengeNotFound
+	}
+
+	// whoever takes the challenge first gets the game
+	challenge, err = c.manager.takeChallenge(ctx, payload.ChallengeID, ChallengeAccepted)
+
+	if err != nil {
+		return err
+	}
+
+	username, _ := c.Data["username"].(string)
+
+	room, err := newStartedRoom(
+		uuid.NewString(),
+		RoomSettings{Mode: challenge.Mode, DaysPerMove: challenge.DaysPerMove, Variant: challenge.Variant},
+		seat{ID: challenge.UserID, Username: challenge.Username},
+		seat{ID: userID, Username: username},
+		resolveColor(challenge.Color),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if err := c.manager.saveStartedRoom(ctx, room); err != nil {
+		return err
+	}
+
+	return c.manager.startGameFor(room, c.manager.getClient(challenge.ClientID), c)
+}
+
+func CancelChallenge(ctx context.Context, e Event, c *Client) error {
+	return answerOwnChallenge(ctx, e, c, ChallengeCancelled, func(challenge *Challenge, userID string) bool {
+		return challenge.UserID == userID
+	})
+}
+
+func DeclineChallenge(ctx context.Context, e Event, c *Client) error {
+	return answerOwnChallenge(ctx, e, c, ChallengeDeclined, func(challenge *Challenge, userID string) bool {
+		return challenge.TargetID == userID
+	})
+}
+
+// Removes a challenge for reason if the user may, as its creator withdrawing it or its target declining it
+func answerOwnChallenge(ctx context.Context, e Event, c *Client, reason string, allowed func(challenge *Challenge, userID string) bool) error {
+	var payload PayloadChallenge
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return err
+	}
+
+	userID, err := c.userID()
+
+	if err != nil {
+		return err
+	}
+
+	challenge, err := c.manager.getChallenge(ctx, payload.ChallengeID)
+
+	if err != nil {
+		return err
+	}
+
+	if !allowed(challenge, userID) {
+		return ErrChallengeNotFound
+	}
+
+	_, err = c.manager.takeChallenge(ctx, payload.ChallengeID, reason)
+
+	return err
+}
+
+// Stores a new challenge, listing it in the lobby unless it is sent to a user
+func (m *Manager) openChallenge(ctx context.Context, challenge *Challenge) error {
+	b, err := json.Marshal(challenge)
+
+	if err != nil {
+		return err
+	}
+
+	userChallengesKey := util.GetUserChallengesKey(challenge.UserID)
+
+	_, err = m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, util.GetChallengeKey(challenge.ID), b, util.ChallengeTTL)
+		pipe.SAdd(ctx, userChallengesKey, challenge.ID)
+		pipe.Expire(ctx, userChallengesKey, util.ChallengeTTL)
+
+		if challenge.TargetID == "" {
+			pipe.ZAdd(ctx, util.LobbyChallengesKey, redis.Z{Score: float64(challenge.CreatedAt), Member: challenge.ID})
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Returns the open challenges, oldest first. Challenges that expired are dropped from the lobby.
+func (m *Manager) lobbyChallenges(ctx context.Context) ([]Challenge, error) {
+	ids, err := m.rdb.ZRange(ctx, util.LobbyChallengesKey, 0, -1).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	challenges := []Challenge{}
+
+	if len(ids) == 0 {
+		return challenges, nil
+	}
+
+	keys := make([]string, len(ids))
+
+	for i, id := range ids {
+		keys[i] = util.GetChallengeKey(id)
+	}
+
+	values, err := m.rdb.MGet(ctx, keys...).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []any{}
+
+	for i, value := range values {
+		s, ok := value.(string)
+
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+
+		var challenge Challenge
+
+		if err := json.Unmarshal([]byte(s), &challenge); err != nil {
+			return nil, err
+		}
+
+		challenges = append(challenges, challenge)
+	}
+
+	if len(expired) > 0 {
+		if err := m.rdb.ZRem(ctx, util.LobbyChallengesKey, expired...).Err(); err != nil {
+			log.Printf("error removing expired challenges from the lobby: %v", err)
+		}
+	}
+
+	return challenges, nil
+}
+
+func (m *Manager) getChallenge(ctx context.Context, id string) (*Challenge, error) {
+	b, err := m.rdb.Get(ctx, util.GetChallengeKey(id)).Bytes()
+
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrChallengeNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var challenge Challenge
+
+	if err := json.Unmarshal(b, &challenge); err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// Removes a challenge and tells the lobby, or both users of a direct challenge, why.
+// Only one caller gets the challenge, the others get ErrChallengeNotFound.
+func (m *Manager) takeChallenge(ctx context.Context, id, reason string) (*Challenge, error) {
+	b, err := m.rdb.GetDel(ctx, util.GetChallengeKey(id)).Bytes()
+
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrChallengeNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var challenge Challenge
+
+	if err := json.Unmarshal(b, &challenge); err != nil {
+		return nil, err
+	}
+
+	_, err = m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, util.LobbyChallengesKey, id)
+		pipe.SRem(ctx, util.GetUserChallengesKey(challenge.UserID), id)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	evt, err := NewEvent(EventChallengeRemoved, PayloadChallengeRemoved{
+		ChallengeID: id,
+		Reason:      reason,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if challenge.TargetID == "" {
+		m.EmitToRoom(util.LobbyRoomID, evt)
+	} else {
+		m.EmitToRoom(challenge.UserID, evt)
+		m.EmitToRoom(challenge.TargetID, evt)
+	}
+
+	return &challenge, nil
+}
+
+// Removes the challenges created from a connection that closed
+func (m *Manager) removeClientChallenges(ctx context.Context, c *Client) {
+	userID, err := c.userID()
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	ids, err := m.rdb.SMembers(ctx, util.GetUserChallengesKey(userID)).Result()
+
+	if err != nil {
+		log.Printf("error listing challenges of user %v: %v", userID, err)
+		return
+	}
+
+	for _, id := range ids {
+		challenge, err := m.getChallenge(ctx, id)
+
+		// the user's other connections may have created some of them
+		if err != nil || challenge.ClientID != c.ID {
+			continue
+		}
+
+		_, err = m.takeChallenge(ctx, id, ChallengeDisconnected)
+
+		if err != nil && !errors.Is(err, ErrChallengeNotFound) {
+			log.Printf("error removing challenge %v: %v", id, err)
+		}
+	}
+}
+
+// Keeps the challenges of connections to this instance from expiring until ctx is cancelled.
+// Every instance runs this for its own connections.
+func (m *Manager) RunChallengeKeepAlive(ctx context.Context) {
+	ticker := time.NewTicker(challengeKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.refreshChallenges(ctx); err != nil {
+				log.Printf("error refreshing challenges: %v", err)
+			}
+		}
+	}
+}
+
+// Extends the challenges created from connections to this instance, up to util.ChallengeMaxAge
+func (m *Manager) refreshChallenges(ctx context.Context) error {
+	m.RLock()
+
+	clientIDs := make(map[string]bool, len(m.clients))
+	userIDs := map[string]bool{}
+
+	for id, client := range m.clients {
+		clientIDs[id] = true
+
+		if userID, ok := client.Data["userID"].(string); ok {
+			userIDs[userID] = true
+		}
+	}
+
+	m.RUnlock()
+
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	members := map[string]*redis.StringSliceCmd{}
+
+	_, err := m.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for userID := range userIDs {
+			members[userID] = pipe.SMembers(ctx, util.GetUserChallengesKey(userID))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	challenges := map[string]*redis.StringCmd{}
+
+	_, err = m.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, ids := range members {
+			for _, id := range ids.Val() {
+				challenges[id] = pipe.Get(ctx, util.GetChallengeKey(id))
+			}
+		}
+
+		return nil
+	})
+
+	// challenges that were taken or expired in the meantime are skipped below
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	_, err = m.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for id, cmd := range challenges {
+			var challenge Challenge
+
+			if cmd.Err() != nil || json.Unmarshal([]byte(cmd.Val()), &challenge) != nil {
+				continue
+			}
+
+			if !clientIDs[challenge.ClientID] || time.Since(time.Unix(challenge.CreatedAt, 0)) > util.ChallengeMaxAge {
+				continue
+			}
+
+			pipe.Expire(ctx, util.GetChallengeKey(id), util.ChallengeTTL)
+			pipe.Expire(ctx, util.GetUserChallengesKey(challenge.UserID), util.ChallengeTTL)
+		}
+
+		return nil
+	})
+
+	return err
+}
@@ -3,31 +3,29 @@ package ws
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/judgegodwins/chess-server/tokens"
+	"github.com/judgegodwins/chess-server/engine"
+	"github.com/judgegodwins/chess-server/metrics"
+	"github.com/judgegodwins/chess-server/ratelimit"
 	"github.com/judgegodwins/chess-server/util"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
 )
 
-var (
-	websocketUpgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin:     checkOrigin,
-	}
-)
+var tracer = otel.Tracer("github.com/judgegodwins/chess-server/ws")
 
 type ClientList map[string]*Client
 
-type wsQuery struct {
-	Token string `form:"token" binding:"required"`
-}
-
 type Manager struct {
 	clients ClientList
 	sync.RWMutex
@@ -35,15 +33,39 @@ type Manager struct {
 	Rooms    map[string][]*Client
 	config   *util.Config
 	rdb      *redis.Client
+	limiter  *ratelimit.RedisLimiter
+	upgrader websocket.Upgrader
+	// plays for bots
+	engine engine.Engine
+	// room events waiting to be appended to their streams
+	roomEvents chan roomEvent
 }
 
 func NewManager(config *util.Config, rdb *redis.Client) *Manager {
 	m := &Manager{
-		clients:  make(ClientList),
-		handlers: make(map[string]EventHandler),
-		Rooms:    make(map[string][]*Client),
-		config:   config,
-		rdb:      rdb,
+		clients:    make(ClientList),
+		handlers:   make(map[string]EventHandler),
+		Rooms:      make(map[string][]*Client),
+		config:     config,
+		rdb:        rdb,
+		limiter:    ratelimit.NewRedisLimiter(rdb),
+		roomEvents: make(chan roomEvent, roomEventsQueueSize),
+	}
+
+	m.upgrader = websocket.Upgrader{
+		ReadBufferSize:    config.WSReadBufferSize,
+		WriteBufferSize:   config.WSWriteBufferSize,
+		EnableCompression: config.WSCompression,
+		CheckOrigin:       m.checkOrigin,
+		// protocol versions take precedence over the auth subprotocol when both are offered
+		Subprotocols: append(append([]string{}, SupportedProtocols...), AuthSubprotocol),
+	}
+
+	// without an external engine bots fall back to the built-in one
+	if config.EnginePath != "" {
+		m.engine = engine.NewUCIPool(config.EnginePath, config.EnginePoolSize)
+	} else {
+		m.engine = engine.NewBuiltin(config.EngineDepth, config.EnginePoolSize)
 	}
 
 	m.setupEventHandlers()
@@ -54,13 +76,41 @@ func NewManager(config *util.Config, rdb *redis.Client) *Manager {
 func (m *Manager) setupEventHandlers() {
 	m.handlers[EventJoinRoom] = JoinGameRoom
 	m.handlers[EventAcceptJoin] = AcceptJoinRequest
+	m.handlers[EventRejectJoin] = RejectJoinRequest
+	m.handlers[EventCancelJoin] = CancelJoinRequest
 	m.handlers[EventPieceMove] = PieceMoveHandler
 	m.handlers[EventCloseRoom] = CloseRoom
+	m.handlers[EventReauth] = ReauthHandler
+	m.handlers[EventResign] = ResignHandler
+	m.handlers[EventDraw] = DrawHandler
+	m.handlers[EventSubscribeLobby] = SubscribeLobby
+	m.handlers[EventUnsubscribeLobby] = UnsubscribeLobby
+	m.handlers[EventCreateChallenge] = CreateChallenge
+	m.handlers[EventAcceptChallenge] = AcceptChallenge
+	m.handlers[EventCancelChallenge] = CancelChallenge
+	m.handlers[EventChallengeUser] = ChallengeUser
+	m.handlers[EventDeclineChallenge] = DeclineChallenge
+	m.handlers[EventOfferRematch] = OfferRematchHandler
+	m.handlers[EventAcceptRematch] = AcceptRematchHandler
 }
 
-func (m *Manager) routeEvent(ctx context.Context, evt Event, c *Client) error {
+func (m *Manager) routeEvent(ctx context.Context, evt Event, c *Client) (err error) {
+	ctx, span := tracer.Start(ctx, "ws.routeEvent", trace.WithAttributes(
+		attribute.String("ws.event.type", evt.Type),
+		attribute.String("ws.event.trace_id", evt.TraceID),
+		attribute.String("ws.client.id", c.ID),
+	))
+
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	if handler, ok := m.handlers[evt.Type]; ok {
-		if err := handler(ctx, evt, c); err != nil {
+		if err := traceHandler(ctx, evt, c, handler); err != nil {
 			return err
 		}
 
@@ -70,6 +120,21 @@ func (m *Manager) routeEvent(ctx context.Context, evt Event, c *Client) error {
 	return errors.New("there is no such event type")
 }
 
+// Runs an event handler inside its own span
+func traceHandler(ctx context.Context, evt Event, c *Client, handler EventHandler) error {
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("ws.handler %v", evt.Type))
+	defer span.End()
+
+	err := handler(ctx, evt, c)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
 func (m *Manager) addClient(client *Client) {
 	m.Lock()
 	defer m.Unlock()
@@ -84,26 +149,24 @@ func (m *Manager) removeClient(client *Client) {
 	delete(m.clients, client.ID)
 }
 
+// Returns the connected client with the id, or nil if it isn't connected to this instance
+func (m *Manager) getClient(id string) *Client {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.clients[id]
+}
+
 // Websocket connection handler
 func (m *Manager) ServeWS(c *gin.Context) {
-	// temporary: use request query to pass token
-	var query wsQuery
-	if err := c.ShouldBindQuery(&query); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{
-			"status":  "error",
-			"message": "token not sent",
-		})
-		return
-	}
+	payload, responseHeader, err := m.authenticateRequest(c)
 
-	payload, err := tokens.ParseJWTToken(query.Token, []byte(m.config.JWTSecret))
-
-	if err != nil {
+	if err != nil && !errors.Is(err, errNoCredentials) {
 		c.IndentedJSON(http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
-	conn, err := websocketUpgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := m.upgrader.Upgrade(c.Writer, c.Request, responseHeader)
 
 	if err != nil {
 		log.Printf("error upgrading to websocket connection: %v\n", err)
@@ -111,13 +174,39 @@ func (m *Manager) ServeWS(c *gin.Context) {
 		return
 	}
 
+	protocol, codec := negotiatedProtocol(conn.Subprotocol())
+
+	// no credentials were sent with the upgrade request, expect an auth event instead
+	if payload == nil {
+		payload, err = m.authenticateFirstMessage(conn, codec)
+
+		if err != nil {
+			log.Printf("websocket authentication failed: %v", err)
+			closePolicyViolation(conn, "authentication failed")
+			return
+		}
+	}
+
+	// only takes effect if the client negotiated permessage-deflate
+	if err := conn.SetCompressionLevel(m.config.WSCompressionLevel); err != nil {
+		log.Printf("error setting compression level: %v", err)
+	}
+
 	client := NewClient(conn, m)
 
+	client.Protocol = protocol
+	client.codec = codec
+
 	client.Data["userID"] = payload.ID
 	client.Data["username"] = payload.Username
+	client.Data["rating"] = payload.Rating
 
 	m.addClient(client)
 
+	if err := m.markOnline(c, client); err != nil {
+		log.Printf("error marking client %v online: %v", client.ID, err)
+	}
+
 	// make client join its own room
 	client.Join(payload.ID)
 
@@ -129,34 +218,54 @@ func (m *Manager) ServeWS(c *gin.Context) {
 		client.LeaveAllRooms()
 		m.removeClient(client)
 
+		if err := m.markOffline(context.Background(), client); err != nil {
+			log.Printf("error marking client %v offline: %v", client.ID, err)
+		}
+
+		// its open challenges can't be accepted anymore
+		m.removeClientChallenges(context.Background(), client)
+
 		client.connection.Close()
 	}()
 
 	go client.readMessages(ctx)
 	go client.writeMessages(ctx)
+	go client.watchTokenExpiry(ctx, payload.ExpiresAt)
 
 	err = <-client.Err()
 
 	log.Printf("Client (%v) error: %v", client.ID, err)
 
+	if errors.Is(err, errTokenExpired) {
+		closePolicyViolation(client.connection, "token expired")
+	}
+
 	c.AbortWithStatus(http.StatusOK)
 }
 
 // Emits an event to a room. Every client in that room receives the event.
 func (m *Manager) EmitToRoom(roomID string, evt Event) {
-	room, ok := m.Rooms[roomID]
+	m.recordRoomEvent(roomID, evt)
 
-	if !ok {
-		return
-	}
-
-	for _, client := range room {
+	for _, client := range m.roomClients(roomID) {
 		client.PushToEgress(evt)
 	}
 }
 
+// Returns a copy of the clients in the room on this instance
+func (m *Manager) roomClients(roomID string) []*Client {
+	// Leave removes clients from the slice in place
+	m.RLock()
+	defer m.RUnlock()
+
+	return slices.Clone(m.Rooms[roomID])
+}
+
 // Checks if a client is in the room
 func (m *Manager) ClientInRoom(roomID string, c *Client) bool {
+	m.RLock()
+	defer m.RUnlock()
+
 	room, ok := m.Rooms[roomID]
 
 	if !ok {
@@ -192,14 +301,17 @@ func (m *Manager) removeRoom(roomID string) {
 	delete(m.Rooms, roomID)
 }
 
-func checkOrigin(r *http.Request) bool {
-	// origin := r.Header.Get("Origin")
-	// switch origin {
-	// case "http://localhost:8080":
-	// 	return true
-	// default:
-	// 	return false
-	// }
+// Only allows websocket upgrades from origins in the configured allowlist.
+// Requests without an Origin header don't come from browsers and are allowed.
+func (m *Manager) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
 
-	return true
+	if origin == "" || m.config.AllowedOrigins.Allowed(origin) {
+		return true
+	}
+
+	log.Printf("rejected websocket upgrade from origin %q", origin)
+	metrics.RejectedOrigins.Add("ws", 1)
+
+	return false
 }
@@ -0,0 +1,101 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+)
+
+var presenceKeepAliveInterval = util.PresenceTTL / 4
+
+// Marks the clients' connections online until util.PresenceTTL from now, so every instance can tell their users are connected
+func (m *Manager) markOnline(ctx context.Context, clients ...*Client) error {
+	now := time.Now()
+	expiresAt := float64(now.Add(util.PresenceTTL).Unix())
+	// connections lost without going offline, like those of an instance that crashed
+	lost := strconv.FormatInt(now.Unix(), 10)
+
+	_, err := m.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, client := range clients {
+			userID, ok := client.Data["userID"].(string)
+
+			if !ok {
+				continue
+			}
+
+			key := util.GetUserConnectionsKey(userID)
+
+			pipe.ZRemRangeByScore(ctx, key, "-inf", "("+lost)
+			pipe.ZAdd(ctx, key, redis.Z{Score: expiresAt, Member: client.ID})
+			pipe.Expire(ctx, key, util.PresenceTTL)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Removes the client's connection from its user's connections
+func (m *Manager) markOffline(ctx context.Context, client *Client) error {
+	userID, err := client.userID()
+
+	if err != nil {
+		return err
+	}
+
+	return m.rdb.ZRem(ctx, util.GetUserConnectionsKey(userID), client.ID).Err()
+}
+
+// Reports whether the user has a connection to any instance
+func (m *Manager) userOnline(ctx context.Context, userID string) (bool, error) {
+	if userID == "" {
+		return false, nil
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	n, err := m.rdb.ZCount(ctx, util.GetUserConnectionsKey(userID), now, "+inf").Result()
+
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// Keeps the connections to this instance online until ctx is cancelled.
+// Every instance runs this for its own connections.
+func (m *Manager) RunPresenceKeepAlive(ctx context.Context) {
+	ticker := time.NewTicker(presenceKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.RLock()
+
+			clients := make([]*Client, 0, len(m.clients))
+
+			for _, client := range m.clients {
+				clients = append(clients, client)
+			}
+
+			m.RUnlock()
+
+			if len(clients) == 0 {
+				continue
+			}
+
+			if err := m.markOnline(ctx, clients...); err != nil {
+				log.Printf("error refreshing presence: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Protocol versions are negotiated with the Sec-WebSocket-Protocol header when connecting,
+// e.g. new WebSocket(url, ["chess.v1"]). Clients that don't ask for a version get the latest.
+// A binary encoding can be requested by suffixing the version, e.g. "chess.v1.msgpack".
+const (
+	ProtocolV1 = "chess.v1"
+
+	LatestProtocol = ProtocolV1
+)
+
+// Wire encodings of events
+const (
+	EncodingJSON     = "json"
+	EncodingMsgpack  = "msgpack"
+	EncodingProtobuf = "protobuf"
+)
+
+var codecs = map[string]Codec{
+	EncodingJSON:     jsonCodec,
+	EncodingMsgpack:  msgpackCodec,
+	EncodingProtobuf: protobufCodec,
+}
+
+// Subprotocols the server speaks, in order of preference. The bare version means JSON.
+var SupportedProtocols = []string{
+	ProtocolV1 + "." + EncodingMsgpack,
+	ProtocolV1 + "." + EncodingProtobuf,
+	ProtocolV1 + "." + EncodingJSON,
+	ProtocolV1,
+}
+
+type EventDirection string
+
+const (
+	DirectionInbound  EventDirection = "inbound"
+	DirectionOutbound EventDirection = "outbound"
+)
+
+// EventSpec documents an event type and the payload it carries
+type EventSpec struct {
+	Type        string
+	Direction   EventDirection
+	Payload     any
+	Description string
+}
+
+// Every event of the latest protocol version. The JSON schema served to clients is generated from this list.
+var ProtocolEvents = []EventSpec{
+	{EventAuth, DirectionInbound, PayloadAuth{}, "Authenticates a connection that sent no credentials with the upgrade request. Must be the first message."},
+	{EventReauth, DirectionInbound, PayloadAuth{}, "Replaces the session's token with a fresh one before it expires."},
+	{EventJoinRoom, DirectionInbound, PayloadJoinRoom{}, "Joins a room as a player, or asks the room creator to join it. Rooms created with auto_accept seat the first user to ask as player2 and send start_game. Private rooms need their invite code, and rooms with a password the password."},
+	{EventAcceptJoin, DirectionInbound, PayloadAcceptJoinRequest{}, "Seats the requesting user as player2 and starts the game. The user must have a pending join request, and only the room's creator can accept it."},
+	{EventRejectJoin, DirectionInbound, PayloadRejectJoinRequest{}, "Rejects a pending request to join the creator's room."},
+	{EventCancelJoin, DirectionInbound, PayloadRoom{}, "Withdraws the user's pending request to join a room."},
+	{EventPieceMove, DirectionInbound, PayloadPieceMove{}, "Plays a move. It is checked against the rules of the room's variant."},
+	{EventResign, DirectionInbound, PayloadRoom{}, "Resigns the game."},
+	{EventDraw, DirectionInbound, PayloadDrawAction{}, "Offers a draw, or accepts or declines the opponent's offer."},
+	{EventCloseRoom, DirectionInbound, PayloadRoom{}, "Deletes a room. Only its creator can close it, pending join requests are turned down."},
+	{EventOfferRematch, DirectionInbound, PayloadRoom{}, "Offers the opponent a rematch after the game. Accepts the opponent's offer if they made one first."},
+	{EventAcceptRematch, DirectionInbound, PayloadRoom{}, "Accepts the opponent's rematch offer."},
+	{EventSubscribeLobby, DirectionInbound, nil, "Starts following the lobby. The open challenges are sent back in a lobby event, then challenge_created and challenge_removed as they change."},
+	{EventUnsubscribeLobby, DirectionInbound, nil, "Stops following the lobby."},
+	{EventCreateChallenge, DirectionInbound, PayloadCreateChallenge{}, "Opens a challenge in the lobby. It is removed when the connection that created it closes."},
+	{EventChallengeUser, DirectionInbound, PayloadChallengeUser{}, "Challenges a user who is online, by their user id. They receive challenge_received, the challenger challenge_created."},
+	{EventAcceptChallenge, DirectionInbound, PayloadChallenge{}, "Accepts a challenge from the lobby or one sent to the user. A room is created with both players seated and start_game is sent to both."},
+	{EventDeclineChallenge, DirectionInbound, PayloadChallenge{}, "Declines a challenge sent to the user."},
+	{EventCancelChallenge, DirectionInbound, PayloadChallenge{}, "Withdraws one of the user's challenges."},
+
+	{EventAuthenticated, DirectionOutbound, PayloadAuthenticated{}, "Sent after a successful auth or reauth."},
+	{EventTokenExpiring, DirectionOutbound, PayloadTokenExpiring{}, "The session's token is about to expire, send reauth to keep the connection open."},
+	{EventRateLimited, DirectionOutbound, PayloadRateLimited{}, "An event was dropped because it exceeded its rate limit."},
+	{EventJoinedRoom, DirectionOutbound, PayloadRoomState{}, "The client joined a room it's a player in."},
+	{EventConnElsewhere, DirectionOutbound, "", "The user joined the room from another connection. Carries the room id."},
+	{EventRoomNotFound, DirectionOutbound, nil, "The room doesn't exist."},
+	{EventRoomFull, DirectionOutbound, nil, "The room already has two players."},
+	{EventRequestJoin, DirectionOutbound, PayloadJoinRequest{}, "A user asks to join the room. Requests expire if the creator doesn't answer them in time."},
+	{EventJoinRequestRemoved, DirectionOutbound, PayloadJoinRequestRemoved{}, "A pending join request was rejected, withdrawn, expired or can't be accepted anymore. Sent to the room and the requesting user."},
+	{EventStartGame, DirectionOutbound, PayloadRoomState{}, "Both players are seated and the game has started."},
+	{EventPieceMove, DirectionOutbound, PayloadPieceMove{}, "A move was played in the room, with the resulting position, the move in UCI and SAN and the number of moves played so far."},
+	{EventOpeningDetected, DirectionOutbound, PayloadOpening{}, "The game reached a position of a known opening, named by its ECO code. Sent when the opening changes, in standard games only."},
+	{EventGameOver, DirectionOutbound, PayloadGameOver{}, "The game ended."},
+	{EventRematchOffered, DirectionOutbound, PayloadRematchOffer{}, "A player offered a rematch."},
+	{EventRematch, DirectionOutbound, PayloadRematch{}, "The rematch was accepted. The players' clients are moved to its room, which is sent start_game with the colours swapped."},
+	{EventDrawOffered, DirectionOutbound, PayloadDraw{}, "A player offered a draw."},
+	{EventDrawDeclined, DirectionOutbound, PayloadDraw{}, "A player declined the draw offer."},
+	{EventAnalysisProgress, DirectionOutbound, PayloadAnalysisProgress{}, "Progress of a game analysis requested with POST /games/:id/analysis, sent to the requesting user after every move."},
+	{EventLobby, DirectionOutbound, PayloadLobby{}, "The open challenges, sent after subscribe_lobby."},
+	{EventChallengeCreated, DirectionOutbound, Challenge{}, "A challenge was opened in the lobby. Also sent to its creator, and to the creator of a direct challenge."},
+	{EventChallengeReceived, DirectionOutbound, Challenge{}, "Another user challenged the user. Answer with accept_challenge or decline_challenge."},
+	{EventChallengeRemoved, DirectionOutbound, PayloadChallengeRemoved{}, "A challenge was removed. Sent to the lobby, or to both users of a direct challenge."},
+	{EventUserConnect, DirectionOutbound, PayloadUser{}, "A player connected to the room."},
+	{EventUserDisconnect, DirectionOutbound, PayloadUser{}, "A player disconnected from the room."},
+	{EventClosingRoom, DirectionOutbound, PayloadRoom{}, "The room was deleted."},
+	{EventRoomState, DirectionOutbound, PayloadRoomState{}, "Snapshot of the room sent to spectators when they start following it over GET /rooms/:id/stream."},
+	{EventAck, DirectionOutbound, PayloadAck{}, "An inbound event with the same trace id was handled successfully."},
+	{EventError + "_<trace_id>", DirectionOutbound, PayloadError{}, "An inbound event with the given trace id failed, or a retry arrived while it was still being handled. Retrying it with the same trace id is safe."},
+}
+
+// Returns the protocol version and codec the client negotiated when connecting
+func negotiatedProtocol(subprotocol string) (string, Codec) {
+	if !slices.Contains(SupportedProtocols, subprotocol) {
+		return LatestProtocol, jsonCodec
+	}
+
+	version, encoding, _ := strings.Cut(strings.TrimPrefix(subprotocol, "chess."), ".")
+
+	if codec, ok := codecs[encoding]; ok {
+		return "chess." + version, codec
+	}
+
+	return "chess." + version, jsonCodec
+}
+
+// Returns the JSON schema of the latest protocol version
+func ProtocolSchema() ([]byte, error) {
+	return json.MarshalIndent(generateSchema(LatestProtocol, ProtocolEvents), "", "  ")
+}
@@ -0,0 +1,75 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/judgegodwins/chess-server/metrics"
+	"golang.org/x/time/rate"
+)
+
+// Checks the event against the connection's local token bucket and then the user's
+// bucket in redis, which is shared by all of the user's connections across instances.
+// Returns false and the time to wait if the event should be dropped.
+func (c *Client) allowEvent(ctx context.Context, evtType string) (bool, time.Duration) {
+	limit, ok := c.manager.config.EventRateLimits.For(evtType)
+
+	if !ok {
+		return true, 0
+	}
+
+	// event types are chosen by the client, those without a limit of their own share the default bucket
+	bucket := c.manager.config.EventRateLimits.Key(evtType)
+
+	// only the readMessages goroutine touches the connection limiters
+	limiter, ok := c.limiters[bucket]
+
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)
+		c.limiters[bucket] = limiter
+	}
+
+	if r := limiter.Reserve(); r.Delay() > 0 {
+		delay := r.Delay()
+		r.Cancel()
+		return false, delay
+	}
+
+	userID, ok := c.Data["userID"].(string)
+
+	if !ok {
+		return true, 0
+	}
+
+	res, err := c.manager.limiter.Allow(ctx, fmt.Sprintf("ws:%v:%v", userID, bucket), limit)
+
+	if err != nil {
+		// fail open so a redis hiccup doesn't lock everyone out
+		log.Printf("error checking rate limit for user %v: %v", userID, err)
+		return true, 0
+	}
+
+	return res.Allowed, res.RetryAfter
+}
+
+// Tells the client an event was dropped because it exceeded its rate limit
+func (c *Client) pushRateLimited(evt Event, retryAfter time.Duration) error {
+	metrics.RateLimited.Add("ws:"+c.manager.config.EventRateLimits.Key(evt.Type), 1)
+
+	e, err := NewEvent(EventRateLimited, PayloadRateLimited{
+		EventType:    evt.Type,
+		RetryAfterMs: retryAfter.Milliseconds(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	e.TraceID = evt.TraceID
+
+	c.PushToEgress(e)
+
+	return nil
+}
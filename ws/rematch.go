@@ -0,0 +1,251 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrGameNotOver    = errors.New("the game isn't over")
+	ErrNoRematchOffer = errors.New("there is no rematch offer to accept")
+	ErrRematchStarted = errors.New("the rematch has already started")
+	ErrRematchOffered = errors.New("you already offered a rematch")
+)
+
+func OfferRematchHandler(ctx context.Context, e Event, c *Client) error {
+	var payload PayloadRoom
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return err
+	}
+
+	userID, err := c.userID()
+
+	if err != nil {
+		return err
+	}
+
+	return c.manager.OfferRematch(ctx, userID, payload.RoomID)
+}
+
+func AcceptRematchHandler(ctx context.Context, e Event, c *Client) error {
+	var payload PayloadRoom
+
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return err
+	}
+
+	userID, err := c.userID()
+
+	if err != nil {
+		return err
+	}
+
+	return c.manager.AcceptRematch(ctx, userID, payload.RoomID)
+}
+
+// Checks that the game in the room is over, userID played it and no rematch has started
+func checkRematch(room map[string]string, userID string) error {
+	if len(room) == 0 {
+		return ErrRoomNotFound
+	}
+
+	if _, ok := playerColor(room, userID); !ok {
+		return ErrNotAPlayer
+	}
+
+	if room[util.RoomResultKey] == "" {
+		return ErrGameNotOver
+	}
+
+	if room[util.RoomRematchKey] != "" {
+		return ErrRematchStarted
+	}
+
+	return nil
+}
+
+// Offers the opponent a rematch after the game. Offering a rematch the opponent
+// already offered accepts it, and bots accept straight away.
+func (m *Manager) OfferRematch(ctx context.Context, userID, roomID string) error {
+	accept := false
+
+	_, err := m.updateRoom(ctx, roomID, func(room map[string]string, pipe redis.Pipeliner) error {
+		if err := checkRematch(room, userID); err != nil {
+			return err
+		}
+
+		opponent := opponentOf(room, userID)
+
+		switch room[util.RoomRematchOfferKey] {
+		case opponent:
+			accept = true
+			return nil
+		case userID:
+			return ErrRematchOffered
+		}
+
+		if isBot(opponent) {
+			accept = true
+			return nil
+		}
+
+		pipe.HSet(ctx, util.GetRoomKey(roomID), util.RoomRematchOfferKey, userID)
+		room[util.RoomRematchOfferKey] = userID
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if accept {
+		return m.AcceptRematch(ctx, userID, roomID)
+	}
+
+	evt, err := NewEvent(EventRematchOffered, PayloadRematchOffer{
+		RoomID: roomID,
+		UserID: userID,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	m.EmitToRoom(roomID, evt)
+
+	return nil
+}
+
+// Accepts the opponent's rematch offer, creating a room for the new game with the same
+// players and settings but swapped colours, and moving the players' clients into it
+func (m *Manager) AcceptRematch(ctx context.Context, userID, roomID string) error {
+	rematchID := uuid.NewString()
+
+	// only the first acceptance starts a rematch
+	room, err := m.updateRoom(ctx, roomID, func(room map[string]string, pipe redis.Pipeliner) error {
+		if err := checkRematch(room, userID); err != nil {
+			return err
+		}
+
+		opponent := opponentOf(room, userID)
+
+		if room[util.RoomRematchOfferKey] != opponent && !isBot(opponent) {
+			return ErrNoRematchOffer
+		}
+
+		roomKey := util.GetRoomKey(roomID)
+
+		pipe.HSet(ctx, roomKey, util.RoomRematchKey, rematchID)
+		pipe.HDel(ctx, roomKey, util.RoomRematchOfferKey)
+		room[util.RoomRematchKey] = rematchID
+		delete(room, util.RoomRematchOfferKey)
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	rematch, err := m.createRematchRoom(ctx, rematchID, room)
+
+	if err != nil {
+		// let the players try again
+		if err := m.rdb.HDel(ctx, util.GetRoomKey(roomID), util.RoomRematchKey).Err(); err != nil {
+			log.Printf("error clearing rematch of room %v: %v", roomID, err)
+		}
+
+		return err
+	}
+
+	evt, err := NewEvent(EventRematch, PayloadRematch{
+		RoomID:        roomID,
+		RematchRoomID: rematchID,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	m.EmitToRoom(roomID, evt)
+
+	clients := m.playerClients(room)
+
+	for _, client := range clients {
+		client.Leave(roomID)
+	}
+
+	if err := m.startGameFor(rematch, clients...); err != nil {
+		return err
+	}
+
+	// the bot opens the rematch when it gets white
+	if isBot(rematch[util.RoomPlayer2Key]) {
+		go m.PlayBotMove(rematchID)
+	}
+
+	return nil
+}
+
+// Creates the room of a rematch of the finished game in room, with the colours swapped
+func (m *Manager) createRematchRoom(ctx context.Context, rematchID string, room map[string]string) (map[string]string, error) {
+	daysPerMove, _ := strconv.Atoi(room[util.RoomDaysPerMoveKey])
+
+	player1Color, _ := playerColor(room, room[util.RoomPlayer1Key])
+
+	rematch, err := newStartedRoom(
+		rematchID,
+		RoomSettings{
+			Mode:        room[util.RoomModeKey],
+			DaysPerMove: daysPerMove,
+			Variant:     room[util.RoomVariantKey],
+			InitialFEN:  room[util.RoomInitialFENKey],
+		},
+		seat{ID: room[util.RoomPlayer1Key], Username: room[util.RoomPlayer1UsernameKey]},
+		seat{ID: room[util.RoomPlayer2Key], Username: room[util.RoomPlayer2UsernameKey]},
+		oppositeColor(player1Color),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{util.RoomBotSkillKey, util.RoomBotMoveTimeKey} {
+		if value, ok := room[key]; ok {
+			rematch[key] = value
+		}
+	}
+
+	if err := m.saveStartedRoom(ctx, rematch); err != nil {
+		return nil, err
+	}
+
+	return rematch, nil
+}
+
+// Returns the players' clients connected to the room on this instance, leaving out spectators
+func (m *Manager) playerClients(room map[string]string) []*Client {
+	m.RLock()
+	defer m.RUnlock()
+
+	clients := []*Client{}
+
+	for _, client := range m.Rooms[room[util.RoomIDKey]] {
+		userID, _ := client.Data["userID"].(string)
+
+		if _, ok := playerColor(room, userID); ok {
+			clients = append(clients, client)
+		}
+	}
+
+	return clients
+}
@@ -0,0 +1,196 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"github.com/judgegodwins/chess-server/chess"
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// colour preference of players who don't mind which side they play
+const ColorRandom = "random"
+
+var (
+	ErrInvalidSettings = errors.New("invalid game settings")
+	ErrRoomFull        = errors.New("the room is full")
+)
+
+// Settings of a game created over the websocket, as by accepting a challenge
+type RoomSettings struct {
+	Mode string
+	// days each player has per move in correspondence games
+	DaysPerMove int
+	Variant     string
+	// starting position, generated for the variant when empty. Only rematches reuse one,
+	// new custom positions can only be set up with POST /rooms.
+	InitialFEN string
+}
+
+// Fills in defaults and checks the settings, returning an error wrapping ErrInvalidSettings
+func (s *RoomSettings) normalize() error {
+	if s.Mode == "" {
+		s.Mode = util.ModeLive
+	}
+
+	if s.Variant == "" {
+		s.Variant = string(chess.Standard)
+	}
+
+	if s.Mode != util.ModeLive && s.Mode != util.ModeCorrespondence {
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidSettings, s.Mode)
+	}
+
+	variant, err := chess.ParseVariant(s.Variant)
+
+	if err != nil || (variant == chess.FromPosition && s.InitialFEN == "") {
+		return fmt.Errorf("%w: unsupported variant %q", ErrInvalidSettings, s.Variant)
+	}
+
+	if s.Mode == util.ModeCorrespondence && (s.DaysPerMove < 1 || s.DaysPerMove > 30) {
+		return fmt.Errorf("%w: days per move must be between 1 and 30", ErrInvalidSettings)
+	}
+
+	if s.Mode == util.ModeLive {
+		s.DaysPerMove = 0
+	}
+
+	return nil
+}
+
+// A player taking a seat in a room
+type seat struct {
+	ID       string
+	Username string
+}
+
+// Returns the colour of a player who asked for color, picking one at random for ColorRandom
+func resolveColor(color string) string {
+	if color == util.ColorWhite || color == util.ColorBlack {
+		return color
+	}
+
+	if rand.Intn(2) == 0 {
+		return util.ColorWhite
+	}
+
+	return util.ColorBlack
+}
+
+// Returns the hash of a room with both players seated and its game started, player1 playing player1Color
+func newStartedRoom(roomID string, settings RoomSettings, player1, player2 seat, player1Color string) (map[string]string, error) {
+	if err := settings.normalize(); err != nil {
+		return nil, err
+	}
+
+	initialFEN := settings.InitialFEN
+
+	if initialFEN == "" {
+		var err error
+
+		if initialFEN, err = chess.StartingFEN(chess.Variant(settings.Variant)); err != nil {
+			return nil, err
+		}
+	}
+
+	room := map[string]string{
+		util.RoomIDKey:              roomID,
+		util.RoomPlayer1Key:         player1.ID,
+		util.RoomPlayer1UsernameKey: player1.Username,
+		util.RoomPlayer2Key:         player2.ID,
+		util.RoomPlayer2UsernameKey: player2.Username,
+		util.RoomGameStateKey:       initialFEN,
+		util.RoomGameStartedKey:     util.GameStartedTrue.String(),
+		util.RoomPlayer1ColorKey:    player1Color,
+		util.RoomModeKey:            settings.Mode,
+		util.RoomVariantKey:         settings.Variant,
+		util.RoomInitialFENKey:      initialFEN,
+	}
+
+	if settings.Mode == util.ModeCorrespondence {
+		room[util.RoomDaysPerMoveKey] = strconv.Itoa(settings.DaysPerMove)
+	}
+
+	return room, nil
+}
+
+// Stores a room built with newStartedRoom
+func (m *Manager) saveStartedRoom(ctx context.Context, room map[string]string) error {
+	roomKey := util.GetRoomKey(room[util.RoomIDKey])
+
+	_, err := m.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, roomKey, room)
+		pipe.Expire(ctx, roomKey, util.RoomTTL)
+
+		if isCorrespondence(room) {
+			startCorrespondenceGame(ctx, pipe, room)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Reports whether the room seats the first user asking to join without the creator accepting
+func AutoAccepts(room map[string]string) bool {
+	return room[util.RoomAutoAcceptKey] == strconv.FormatBool(true)
+}
+
+// Seats the player as player2 and starts the room's game. Only one player gets the seat,
+// the others get ErrRoomFull.
+func (m *Manager) seatOpponent(ctx context.Context, roomID string, player seat) (map[string]string, error) {
+	return m.updateRoom(ctx, roomID, func(room map[string]string, pipe redis.Pipeliner) error {
+		if len(room) == 0 || room[util.RoomPlayer1Key] == "" {
+			return ErrRoomNotFound
+		}
+
+		if room[util.RoomPlayer2Key] != "" {
+			return ErrRoomFull
+		}
+
+		room[util.RoomPlayer2Key] = player.ID
+		room[util.RoomPlayer2UsernameKey] = player.Username
+		room[util.RoomGameStartedKey] = util.GameStartedTrue.String()
+
+		pipe.HSet(ctx, util.GetRoomKey(roomID),
+			util.RoomPlayer2Key, player.ID,
+			util.RoomPlayer2UsernameKey, player.Username,
+			util.RoomGameStartedKey, util.GameStartedTrue.String(),
+		)
+
+		// the room isn't looking for an opponent anymore
+		pipe.ZRem(ctx, util.PublicRoomsKey, roomID)
+
+		if isCorrespondence(room) {
+			startCorrespondenceGame(ctx, pipe, room)
+		}
+
+		return nil
+	})
+}
+
+// Makes the clients join the room and tells them the game started
+func (m *Manager) startGameFor(room map[string]string, clients ...*Client) error {
+	roomID := room[util.RoomIDKey]
+
+	for _, client := range clients {
+		if client != nil {
+			client.Join(roomID)
+		}
+	}
+
+	evt, err := NewEvent(EventStartGame, NewRoomState(room))
+
+	if err != nil {
+		return err
+	}
+
+	m.EmitToRoom(roomID, evt)
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package ws
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//go:generate go run ../cmd/schemagen -o ../docs/protocol.schema.json
+
+type jsonSchema = map[string]any
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// Generates a JSON schema (draft 2020-12) validating every event envelope of the protocol.
+// Each event is described by its own definition. The "inbound" and "outbound" definitions
+// match any event sent in that direction, and the envelope matches either of them.
+// anyOf rather than oneOf, piece_move is sent both ways and would match two definitions.
+func generateSchema(protocol string, events []EventSpec) jsonSchema {
+	defs := jsonSchema{}
+	refs := map[EventDirection][]jsonSchema{}
+
+	for _, spec := range events {
+		name := string(spec.Direction) + ":" + spec.Type
+
+		eventType := jsonSchema{"const": spec.Type}
+
+		if i := strings.Index(spec.Type, "<"); i >= 0 {
+			eventType = jsonSchema{"type": "string", "pattern": "^" + spec.Type[:i]}
+		}
+
+		defs[name] = jsonSchema{
+			"description": spec.Description,
+			"type":        "object",
+			"properties": jsonSchema{
+				"type":        eventType,
+				"trace_id":    jsonSchema{"type": "string"},
+				"traceparent": jsonSchema{"type": "string"},
+				"tracestate":  jsonSchema{"type": "string"},
+				"payload":     typeSchema(reflect.TypeOf(spec.Payload)),
+			},
+			"required": []string{"type", "payload"},
+		}
+
+		refs[spec.Direction] = append(refs[spec.Direction], jsonSchema{"$ref": "#/$defs/" + name})
+	}
+
+	defs[string(DirectionInbound)] = jsonSchema{
+		"description": "Any event sent by the client.",
+		"anyOf":       refs[DirectionInbound],
+	}
+	defs[string(DirectionOutbound)] = jsonSchema{
+		"description": "Any event sent by the server.",
+		"anyOf":       refs[DirectionOutbound],
+	}
+
+	return jsonSchema{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         "https://github.com/judgegodwins/chess-server/protocol/" + protocol,
+		"title":       "Chess server websocket protocol " + protocol,
+		"description": "Events exchanged over the websocket. Definitions are keyed by direction and event type.",
+		"anyOf": []jsonSchema{
+			{"$ref": "#/$defs/" + string(DirectionInbound)},
+			{"$ref": "#/$defs/" + string(DirectionOutbound)},
+		},
+		"$defs": defs,
+	}
+}
+
+// Returns the JSON schema of values of type t, as encoded by encoding/json
+func typeSchema(t reflect.Type) jsonSchema {
+	if t == nil {
+		return jsonSchema{"type": "null"}
+	}
+
+	switch t {
+	case timeType:
+		return jsonSchema{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return jsonSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema{"anyOf": []jsonSchema{typeSchema(t.Elem()), {"type": "null"}}}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonSchema{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+
+	return jsonSchema{}
+}
+
+func structSchema(t reflect.Type) jsonSchema {
+	properties := jsonSchema{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := typeSchema(field.Type)
+
+		if enum := field.Tag.Get("enum"); enum != "" {
+			schema["enum"] = strings.Split(enum, ",")
+		}
+
+		properties[name] = schema
+
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	return jsonSchema{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/judgegodwins/chess-server/util"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// how many events are kept per room for spectators to resume from
+	roomEventsMaxLen = 1000
+	// how many room events can wait to be recorded before new ones are dropped
+	roomEventsQueueSize = 1024
+	// how many room events are written to redis in one round trip
+	roomEventsBatchSize = 100
+)
+
+// Events spectators can follow without being in the room
+var spectatorEvents = map[string]bool{
+	EventStartGame:       true,
+	EventPieceMove:       true,
+	EventUserConnect:     true,
+	EventUserDisconnect:  true,
+	EventClosingRoom:     true,
+	EventGameOver:        true,
+	EventDrawOffered:     true,
+	EventDrawDeclined:    true,
+	EventOpeningDetected: true,
+	EventRematchOffered:  true,
+	EventRematch:         true,
+}
+
+// An event waiting to be appended to its room's event stream
+type roomEvent struct {
+	roomID string
+	data   []byte
+}
+
+// Queues an event emitted to a room to be appended to the room's event stream in redis, so
+// spectators on any instance can follow the game over server-sent events and resume after
+// reconnecting. Events are dropped if RunRoomEventRecorder falls too far behind.
+func (m *Manager) recordRoomEvent(roomID string, evt Event) {
+	if !spectatorEvents[evt.Type] {
+		return
+	}
+
+	data, err := jsonCodec.Encode(evt)
+
+	if err != nil {
+		log.Printf("error encoding room event: %v", err)
+		return
+	}
+
+	select {
+	case m.roomEvents <- roomEvent{roomID: roomID, data: data}:
+	default:
+		log.Printf("dropping %v event for room %v, the recorder is behind", evt.Type, roomID)
+	}
+}
+
+// Appends queued room events to their streams until ctx is cancelled. Events queued
+// together are written in one round trip, in the order they were emitted.
+func (m *Manager) RunRoomEventRecorder(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-m.roomEvents:
+			batch := []roomEvent{evt}
+
+		drain:
+			for len(batch) < roomEventsBatchSize {
+				select {
+				case evt := <-m.roomEvents:
+					batch = append(batch, evt)
+				default:
+					break drain
+				}
+			}
+
+			if err := m.writeRoomEvents(ctx, batch); err != nil {
+				log.Printf("error recording %v room events: %v", len(batch), err)
+			}
+		}
+	}
+}
+
+func (m *Manager) writeRoomEvents(ctx context.Context, batch []roomEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := m.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, evt := range batch {
+			key := util.GetRoomEventsKey(evt.roomID)
+
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: key,
+				MaxLen: roomEventsMaxLen,
+				Approx: true,
+				Values: map[string]interface{}{"event": evt.data},
+			})
+			pipe.Expire(ctx, key, util.RoomTTL)
+		}
+
+		return nil
+	})
+
+	return err
+}